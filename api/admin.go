@@ -0,0 +1,312 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pablo-ruth/terraform-state-locker/blob"
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+type AdminLock struct {
+	LockID string    `json:"LockID"`
+	Owner  string    `json:"Owner,omitempty"`
+	Info   *LockInfo `json:"Info,omitempty"`
+}
+
+// AdminDigest is the digest of the state at Path, stored under LockID.
+type AdminDigest struct {
+	LockID string `json:"LockID"`
+	Path   string `json:"Path"`
+	Digest string `json:"Digest"`
+}
+
+type ResetDigestRequest struct {
+	Digest string `json:"Digest"`
+}
+
+// requireBearer refuses the requests that do not carry token as a bearer
+// token.
+func requireBearer(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("Unauthorized"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// adminRouter serves the admin API, every request must carry token as a
+// bearer token. queue is nil unless fairness mode is enabled.
+func adminRouter(s *store.Publisher, opts Options, queue *waitQueue, freezes *freezeSchedule) http.Handler {
+
+	r := chi.NewRouter()
+	r.Use(requireBearer(opts.AdminToken))
+	r.Get("/tables/{table}/locks", func(w http.ResponseWriter, r *http.Request) {
+		handleListLocks(w, r, s)
+	})
+	r.Get("/events", func(w http.ResponseWriter, r *http.Request) {
+		handleEvents(w, r, s, opts)
+	})
+	r.Get("/freezes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, freezes.list())
+	})
+	// Change freezes only block the acquisitions of the node they are added
+	// to, which is the leader for every write in a cluster
+	r.Post("/freezes", func(w http.ResponseWriter, r *http.Request) {
+		if rejectOnFollower(w, opts.Cluster) {
+			return
+		}
+		handleAddFreeze(w, r, freezes)
+	})
+	r.Delete("/freezes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if rejectOnFollower(w, opts.Cluster) {
+			return
+		}
+		if !freezes.remove(chi.URLParam(r, "id")) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Not found"))
+			return
+		}
+		log.Printf("Change freeze %s canceled", chi.URLParam(r, "id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	r.Get("/tables/{table}/queues", func(w http.ResponseWriter, r *http.Request) {
+		queues := []WaitQueue{}
+		if queue != nil {
+			queues = queue.list(chi.URLParam(r, "table"))
+		}
+		writeJSON(w, queues)
+	})
+	r.Get("/tables/{table}/digests", func(w http.ResponseWriter, r *http.Request) {
+		handleListDigests(w, r, s)
+	})
+	r.Put("/tables/{table}/digests/*", func(w http.ResponseWriter, r *http.Request) {
+		handleResetDigest(w, r, s, opts)
+	})
+	r.Delete("/tables/{table}/digests/*", func(w http.ResponseWriter, r *http.Request) {
+		handleDeleteDigest(w, r, s)
+	})
+	r.Get("/backup", func(w http.ResponseWriter, r *http.Request) {
+		handleBackup(w, r, s)
+	})
+	r.Post("/restore", func(w http.ResponseWriter, r *http.Request) {
+		handleRestore(w, r, s)
+	})
+	r.Post("/replication/promote", func(w http.ResponseWriter, r *http.Request) {
+		handlePromote(w, r, opts.Replication)
+	})
+
+	return r
+}
+
+// handleListLocks lists the locks held in a table, digest entries excluded.
+func handleListLocks(w http.ResponseWriter, r *http.Request, s store.Store) {
+
+	entries, ok := scanTable(w, r, s, chi.URLParam(r, "table"))
+	if !ok {
+		return
+	}
+
+	locks := []AdminLock{}
+	for _, entry := range entries {
+		if entry.Kind != store.KindLock {
+			continue
+		}
+
+		lock := AdminLock{LockID: entry.ID, Owner: entry.Owner}
+		if lockInfo, err := ParseLockInfo(entry.Attributes["Info"]); err == nil {
+			lock.Info = &lockInfo
+		}
+		locks = append(locks, lock)
+	}
+
+	writeJSON(w, locks)
+}
+
+func handleListDigests(w http.ResponseWriter, r *http.Request, s store.Store) {
+
+	entries, ok := scanTable(w, r, s, chi.URLParam(r, "table"))
+	if !ok {
+		return
+	}
+
+	digests := []AdminDigest{}
+	for _, entry := range entries {
+		if entry.Kind != store.KindDigest {
+			continue
+		}
+
+		digests = append(digests, AdminDigest{
+			LockID: entry.ID,
+			Path:   strings.TrimSuffix(entry.ID, store.DigestSuffix),
+			Digest: entry.Attributes["Digest"],
+		})
+	}
+
+	writeJSON(w, digests)
+}
+
+// handleResetDigest overwrites the digest of a state, typically after the
+// state was restored by hand and Terraform refuses it because its digest no
+// longer matches. Without a digest in the request, it is computed from the
+// state object when the S3 API is served.
+func handleResetDigest(w http.ResponseWriter, r *http.Request, s store.Store, opts Options) {
+
+	table, path := chi.URLParam(r, "table"), chi.URLParam(r, "*")
+
+	var resetDigestRequest ResetDigestRequest
+	err := json.NewDecoder(r.Body).Decode(&resetDigestRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	digest := resetDigestRequest.Digest
+	if digest == "" {
+		if opts.BlobStore == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Digest is missing"))
+			return
+		}
+
+		bucket, key, _ := strings.Cut(path, "/")
+		object, _, err := opts.BlobStore.Get(bucket, key, "")
+		if err != nil {
+			if err == blob.ErrObjectNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("State not found"))
+				return
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Internal server error"))
+			log.Printf("Getting state: %v", err)
+			return
+		}
+		digest = object.ETag
+	}
+
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != 16 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Digest must be a hex encoded MD5"))
+		return
+	}
+
+	lockID := path + store.DigestSuffix
+	_, _, err = s.Put(r.Context(), table, store.Item{ID: lockID, Attributes: map[string]string{"Digest": digest}}, nil)
+	if rejectReadOnly(w, err) {
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Storing digest: %v", err)
+		return
+	}
+	log.Printf("Digest of %s in %s reset to %s", path, table, digest)
+
+	writeJSON(w, AdminDigest{LockID: lockID, Path: path, Digest: digest})
+}
+
+func handleDeleteDigest(w http.ResponseWriter, r *http.Request, s store.Store) {
+
+	table, path := chi.URLParam(r, "table"), chi.URLParam(r, "*")
+	_, err := s.Delete(r.Context(), table, path+store.DigestSuffix, nil)
+	if err != nil {
+		if errors.Is(err, store.ErrEntryNotFound) || errors.Is(err, store.ErrTableNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Not found"))
+			return
+		}
+		if rejectReadOnly(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Deleting digest: %v", err)
+		return
+	}
+	log.Printf("Digest of %s in %s deleted", path, table)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAddFreeze adds an ad-hoc change freeze, starting immediately unless
+// it has a start.
+func handleAddFreeze(w http.ResponseWriter, r *http.Request, freezes *freezeSchedule) {
+
+	var window FreezeWindow
+	err := json.NewDecoder(r.Body).Decode(&window)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	if window.Start.IsZero() {
+		window.Start = time.Now().UTC()
+	}
+	err = window.validate()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	window, err = freezes.add(window)
+	if errors.Is(err, ErrDuplicateFreeze) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Change freeze already exists"))
+		return
+	}
+	log.Printf("Change freeze %s scheduled from %s to %s", window.ID, window.Start, window.End)
+
+	writeJSON(w, window)
+}
+
+// scanTable returns every entry of a table, writing the error response itself
+// when it fails.
+func scanTable(w http.ResponseWriter, r *http.Request, s store.Store, table string) ([]store.Entry, bool) {
+
+	entries, err := s.Scan(r.Context(), table, "", 0)
+	if err != nil && !errors.Is(err, store.ErrTableNotFound) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Scanning table: %v", err)
+		return nil, false
+	}
+
+	return entries, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+
+	respJSON, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pablo-ruth/terraform-state-locker/blob"
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+func TestAdmin(t *testing.T) {
+
+	ctx := context.Background()
+	s := store.NewInMemoryStore()
+	blobs := blob.NewInMemoryStore(false)
+	server := httptest.NewServer(adminRouter(newPublisher(s), Options{AdminToken: "secret", BlobStore: blobs}, nil, newFreezeSchedule(nil)))
+	defer server.Close()
+
+	info := `{"ID":"bc4abeab-0f07-e6b0-8b6d-a68460074a8e","Operation":"OperationTypePlan","Info":"","Who":"alice@host","Version":"1.5.0","Created":"2023-01-02T03:04:05Z","Path":"tfstates/network"}`
+
+	cases := []struct {
+		name           string
+		method         string
+		target         string
+		token          string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "missing token",
+			method:         "GET",
+			target:         "/tables/terraform-lock-table/locks",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   "Unauthorized",
+		},
+		{
+			name:           "list locks",
+			method:         "GET",
+			target:         "/tables/terraform-lock-table/locks",
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"LockID":"tfstates/network","Owner":"AKIDALICE","Info":` + info + `}]`,
+		},
+		{
+			name:           "list digests",
+			method:         "GET",
+			target:         "/tables/terraform-lock-table/digests",
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"LockID":"tfstates/network-md5","Path":"tfstates/network","Digest":"00000000000000000000000000000000"}]`,
+		},
+		{
+			name:           "reset digest with an invalid digest",
+			method:         "PUT",
+			target:         "/tables/terraform-lock-table/digests/tfstates/network",
+			token:          "secret",
+			body:           `{"Digest":"abc"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Digest must be a hex encoded MD5",
+		},
+		{
+			name:           "reset digest from the state",
+			method:         "PUT",
+			target:         "/tables/terraform-lock-table/digests/tfstates/network",
+			token:          "secret",
+			body:           `{}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"LockID":"tfstates/network-md5","Path":"tfstates/network","Digest":"` + blob.ETag([]byte(`{"version":4}`)) + `"}`,
+		},
+		{
+			name:           "delete digest",
+			method:         "DELETE",
+			target:         "/tables/terraform-lock-table/digests/tfstates/network",
+			token:          "secret",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "list deleted digests",
+			method:         "GET",
+			target:         "/tables/terraform-lock-table/digests",
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+	}
+
+	_, _, err := s.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/network", Attributes: map[string]string{"Info": info}, Owner: "AKIDALICE"}, store.NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, _, err = s.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/network-md5", Attributes: map[string]string{"Digest": "00000000000000000000000000000000"}}, store.NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, err = blobs.Put("tfstates", "network", []byte(`{"version":4}`))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, server.URL+c.target, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			if c.token != "" {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+
+			if resp.StatusCode != c.expectedStatus || string(body) != c.expectedBody {
+				t.Errorf("Expected %d %s, got %d %s", c.expectedStatus, c.expectedBody, resp.StatusCode, body)
+			}
+		})
+	}
+}
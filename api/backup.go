@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pablo-ruth/terraform-state-locker/backup"
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+// handleBackup writes a backup of every table, gzipped with ?gzip=true.
+func handleBackup(w http.ResponseWriter, r *http.Request, s store.Store) {
+
+	archive, err := backup.Export(r.Context(), s)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Exporting tables: %v", err)
+		return
+	}
+
+	compress := r.URL.Query().Get("gzip") == "true"
+	filename := fmt.Sprintf("locker-backup-%s.json", archive.Created.Format("20060102T150405Z"))
+	if compress {
+		filename += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	err = backup.Write(w, archive, compress)
+	if err != nil {
+		log.Printf("Writing backup: %v", err)
+	}
+}
+
+// handleRestore restores the backup in the request body with the import
+// mode of ?mode=, merge by default, and returns the changes made. With
+// ?dry-run=true, the changes are only listed.
+func handleRestore(w http.ResponseWriter, r *http.Request, s store.Store) {
+
+	mode := backup.Merge
+	if r.URL.Query().Get("mode") != "" {
+		var err error
+		mode, err = backup.ParseMode(r.URL.Query().Get("mode"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	dryRun := r.URL.Query().Get("dry-run") == "true"
+
+	archive, err := backup.Read(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid backup"))
+		log.Printf("Reading backup: %v", err)
+		return
+	}
+
+	changes, err := backup.Import(r.Context(), s, archive, mode, dryRun)
+	if rejectReadOnly(w, err) {
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Restoring backup: %v", err)
+		return
+	}
+	if !dryRun {
+		log.Printf("Backup of %s restored in %s mode, %d changes", archive.Created.Format(time.RFC3339), mode, len(changes))
+	}
+
+	writeJSON(w, changes)
+}
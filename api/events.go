@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+// eventHistory is the number of changes kept for clients resuming a stream.
+const eventHistory = 1024
+
+// LockEvent is the data of a Server-Sent Event. Info is omitted when the
+// Info attribute is not a lock info.
+type LockEvent struct {
+	Table        string    `json:"Table"`
+	LockID       string    `json:"LockID"`
+	FencingToken string    `json:"FencingToken,omitempty"`
+	Info         *LockInfo `json:"Info,omitempty"`
+	Time         time.Time `json:"Time"`
+}
+
+func newPublisher(s store.Store) *store.Publisher {
+	return store.NewPublisher(s, eventHistory)
+}
+
+// handleEvents streams the acquire, release and expiry events of locks as
+// Server-Sent Events, optionally only those of the table query parameter.
+// Event IDs are sequence numbers: a new client receives the events from now
+// on, and a client reconnecting with Last-Event-ID the ones it missed as long
+// as they are still retained. A client too slow to keep up is disconnected
+// rather than slowing writes down.
+func handleEvents(w http.ResponseWriter, r *http.Request, publisher *store.Publisher, opts Options) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Streaming is not supported"))
+		return
+	}
+
+	lastSeq := uint64(math.MaxUint64)
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		var err error
+		lastSeq, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid Last-Event-ID"))
+			return
+		}
+	}
+
+	table := r.URL.Query().Get("table")
+	missed, sub := publisher.Subscribe(lastSeq)
+	defer publisher.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, change := range missed {
+		writeEvent(w, change, table, opts)
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case change, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			writeEvent(w, change, table, opts)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, change store.Change, table string, opts Options) {
+
+	if change.Kind != store.KindLock || change.Table == opts.HTTPStateTable || (table != "" && change.Table != table) {
+		return
+	}
+	if change.Type != store.ChangeAcquire && change.Type != store.ChangeRelease && change.Type != store.ChangeExpiry {
+		return
+	}
+
+	event := LockEvent{
+		Table:        change.Table,
+		LockID:       change.ID,
+		FencingToken: change.Attributes[store.FencingTokenAttribute],
+		Time:         change.Time,
+	}
+	if lockInfo, err := ParseLockInfo(change.Attributes["Info"]); err == nil {
+		event.Info = &lockInfo
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Seq, change.Type, data)
+}
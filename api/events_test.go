@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+func TestEvents(t *testing.T) {
+
+	ctx := context.Background()
+
+	cases := []struct {
+		name        string
+		lastEventID string
+		expected    string
+	}{
+		{
+			name:     "new client starts at the live tail",
+			expected: "id: 3",
+		},
+		{
+			name:        "resume after the last event seen",
+			lastEventID: "1",
+			expected:    "id: 2",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			publisher := newPublisher(store.NewInMemoryStore())
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handleEvents(w, r, publisher, Options{})
+			}))
+			defer server.Close()
+
+			for _, id := range []string{"tfstates/network", "tfstates/dns"} {
+				_, _, err := publisher.Put(ctx, "terraform-lock-table", store.Item{ID: id, Attributes: map[string]string{"Info": "Test"}}, store.NotExists)
+				if err != nil {
+					t.Fatalf("Put: %v", err)
+				}
+			}
+
+			req, err := http.NewRequest("GET", server.URL, nil)
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			if c.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", c.lastEventID)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+
+			_, _, err = publisher.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/app", Attributes: map[string]string{"Info": "Test"}}, store.NotExists)
+			if err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			line, err := bufio.NewReader(resp.Body).ReadString('\n')
+			if err != nil {
+				t.Fatalf("ReadString: %v", err)
+			}
+			if strings.TrimSpace(line) != c.expected {
+				t.Errorf("Expected %q, got %q", c.expected, line)
+			}
+		})
+	}
+}
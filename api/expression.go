@@ -0,0 +1,610 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// condition is a compiled DynamoDB condition or filter expression evaluated
+// against the attributes of an item, including its LockID.
+type condition func(attributes map[string]string) bool
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenName
+	tokenValue
+	tokenNumber
+	tokenOperator
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(expression string) ([]token, error) {
+
+	var tokens []token
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '#' || r == ':' || unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			kind := tokenIdent
+			if r == '#' {
+				kind = tokenName
+			} else if r == ':' {
+				kind = tokenValue
+			}
+			if (kind == tokenName || kind == tokenValue) && j == i+1 {
+				return nil, fmt.Errorf("empty placeholder at position %d", i)
+			}
+			tokens = append(tokens, token{kind: kind, text: string(runes[i:j])})
+			i = j
+		case unicode.IsDigit(r):
+			j := i + 1
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[i:j])})
+			i = j
+		case r == '<' || r == '>':
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				tokens = append(tokens, token{kind: tokenOperator, text: string(runes[i : i+2])})
+				i += 2
+				continue
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: string(r)})
+			i++
+		case r == '=':
+			tokens = append(tokens, token{kind: tokenOperator, text: "="})
+			i++
+		case strings.ContainsRune("(),.[]", r):
+			tokens = append(tokens, token{kind: tokenPunct, text: string(r)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// expressionParser is a recursive descent parser over the tokens of an
+// expression, resolving #name and :value placeholders as it goes.
+type expressionParser struct {
+	tokens []token
+	pos    int
+	names  map[string]string
+	values map[string]Item
+}
+
+func newExpressionParser(expression string, names map[string]string, values map[string]Item) (*expressionParser, error) {
+
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &expressionParser{tokens: tokens, names: names, values: values}, nil
+}
+
+func (p *expressionParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *expressionParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *expressionParser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+func (p *expressionParser) expect(kind tokenKind, text string) error {
+	t := p.next()
+	if t.kind != kind || t.text != text {
+		return fmt.Errorf("expected %q, got %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *expressionParser) done() error {
+	if t := p.peek(); t.kind != tokenEOF {
+		return fmt.Errorf("unexpected token %q", t.text)
+	}
+	return nil
+}
+
+// path parses a document path such as "LockID", "#n" or "Info.ID[0]" and
+// returns its elements, list indexes being rendered as "[n]".
+func (p *expressionParser) path() ([]string, error) {
+
+	var elements []string
+	name, err := p.pathElement()
+	if err != nil {
+		return nil, err
+	}
+	elements = append(elements, name)
+
+	for {
+		t := p.peek()
+		if t.kind != tokenPunct || (t.text != "." && t.text != "[") {
+			return elements, nil
+		}
+		p.next()
+
+		if t.text == "." {
+			name, err := p.pathElement()
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, name)
+			continue
+		}
+
+		index := p.next()
+		if index.kind != tokenNumber {
+			return nil, fmt.Errorf("expected list index, got %q", index.text)
+		}
+		if err := p.expect(tokenPunct, "]"); err != nil {
+			return nil, err
+		}
+		elements = append(elements, "["+index.text+"]")
+	}
+}
+
+func (p *expressionParser) pathElement() (string, error) {
+
+	t := p.next()
+	switch t.kind {
+	case tokenIdent:
+		return t.text, nil
+	case tokenName:
+		name, ok := p.names[t.text]
+		if !ok {
+			return "", fmt.Errorf("undefined attribute name placeholder %s", t.text)
+		}
+		return name, nil
+	default:
+		return "", fmt.Errorf("expected attribute name, got %q", t.text)
+	}
+}
+
+// operand returns a function resolving the operand against an item, and
+// whether the operand is present at all.
+func (p *expressionParser) operand() (func(attributes map[string]string) (string, bool), error) {
+
+	if t := p.peek(); t.kind == tokenValue {
+		p.next()
+		value, ok := p.values[t.text]
+		if !ok {
+			return nil, fmt.Errorf("undefined attribute value placeholder %s", t.text)
+		}
+		return func(map[string]string) (string, bool) { return value.S, true }, nil
+	}
+
+	if p.isKeyword("size") {
+		return nil, fmt.Errorf("function size is not supported")
+	}
+
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(attributes map[string]string) (string, bool) {
+		if len(path) != 1 {
+			return "", false
+		}
+		value, ok := attributes[path[0]]
+		return value, ok
+	}, nil
+}
+
+func (p *expressionParser) condition() (condition, error) {
+
+	left, err := p.conjunction()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.conjunction()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(attributes map[string]string) bool { return l(attributes) || right(attributes) }
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) conjunction() (condition, error) {
+
+	left, err := p.negation()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.negation()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(attributes map[string]string) bool { return l(attributes) && right(attributes) }
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) negation() (condition, error) {
+
+	if p.isKeyword("NOT") {
+		p.next()
+		c, err := p.negation()
+		if err != nil {
+			return nil, err
+		}
+		return func(attributes map[string]string) bool { return !c(attributes) }, nil
+	}
+
+	return p.predicate()
+}
+
+func (p *expressionParser) predicate() (condition, error) {
+
+	if t := p.peek(); t.kind == tokenPunct && t.text == "(" {
+		p.next()
+		c, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenPunct, ")"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	if t := p.peek(); t.kind == tokenIdent && p.tokens[p.pos+1].kind == tokenPunct && p.tokens[p.pos+1].text == "(" {
+		return p.function()
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.isKeyword("BETWEEN") {
+		p.next()
+		low, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isKeyword("AND") {
+			return nil, fmt.Errorf("expected AND in BETWEEN")
+		}
+		p.next()
+		high, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return func(attributes map[string]string) bool {
+			v, ok1 := left(attributes)
+			l, ok2 := low(attributes)
+			h, ok3 := high(attributes)
+			return ok1 && ok2 && ok3 && l <= v && v <= h
+		}, nil
+	}
+
+	if p.isKeyword("IN") {
+		p.next()
+		if err := p.expect(tokenPunct, "("); err != nil {
+			return nil, err
+		}
+		var candidates []func(map[string]string) (string, bool)
+		for {
+			candidate, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, candidate)
+			if t := p.next(); t.kind == tokenPunct && t.text == ")" {
+				break
+			} else if t.kind != tokenPunct || t.text != "," {
+				return nil, fmt.Errorf("expected \",\" or \")\" in IN list, got %q", t.text)
+			}
+		}
+		return func(attributes map[string]string) bool {
+			v, ok := left(attributes)
+			if !ok {
+				return false
+			}
+			for _, candidate := range candidates {
+				if c, ok := candidate(attributes); ok && c == v {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	op := p.next()
+	if op.kind != tokenOperator {
+		return nil, fmt.Errorf("expected comparator, got %q", op.text)
+	}
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	var compare func(a, b string) bool
+	switch op.text {
+	case "=":
+		compare = func(a, b string) bool { return a == b }
+	case "<>":
+		compare = func(a, b string) bool { return a != b }
+	case "<":
+		compare = func(a, b string) bool { return a < b }
+	case "<=":
+		compare = func(a, b string) bool { return a <= b }
+	case ">":
+		compare = func(a, b string) bool { return a > b }
+	case ">=":
+		compare = func(a, b string) bool { return a >= b }
+	}
+
+	return func(attributes map[string]string) bool {
+		a, ok1 := left(attributes)
+		b, ok2 := right(attributes)
+		return ok1 && ok2 && compare(a, b)
+	}, nil
+}
+
+func (p *expressionParser) function() (condition, error) {
+
+	name := p.next().text
+	p.next()
+
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	value := func(attributes map[string]string) (string, bool) {
+		if len(path) != 1 {
+			return "", false
+		}
+		v, ok := attributes[path[0]]
+		return v, ok
+	}
+
+	var c condition
+	switch name {
+	case "attribute_exists":
+		c = func(attributes map[string]string) bool {
+			_, ok := value(attributes)
+			return ok
+		}
+	case "attribute_not_exists":
+		c = func(attributes map[string]string) bool {
+			_, ok := value(attributes)
+			return !ok
+		}
+	case "attribute_type", "begins_with", "contains":
+		if err := p.expect(tokenPunct, ","); err != nil {
+			return nil, err
+		}
+		argument, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		c = func(attributes map[string]string) bool {
+			v, ok1 := value(attributes)
+			a, ok2 := argument(attributes)
+			if !ok1 || !ok2 {
+				return false
+			}
+			switch name {
+			case "attribute_type":
+				return a == "S"
+			case "begins_with":
+				return strings.HasPrefix(v, a)
+			default:
+				return strings.Contains(v, a)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported function %s", name)
+	}
+
+	if err := p.expect(tokenPunct, ")"); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// parseCondition compiles a DynamoDB condition or filter expression. Every
+// attribute is a string, so only string comparisons and the functions that
+// make sense on strings are supported.
+func parseCondition(expression string, names map[string]string, values map[string]Item) (condition, error) {
+
+	p, err := newExpressionParser(expression, names, values)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := p.condition()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, p.done()
+}
+
+// parseKeyCondition parses the KeyConditionExpression of a Query, which must
+// be an equality on the LockID hash key, and returns the requested LockID.
+func parseKeyCondition(expression string, names map[string]string, values map[string]Item) (string, error) {
+
+	p, err := newExpressionParser(expression, names, values)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := p.path()
+	if err != nil {
+		return "", err
+	}
+	if len(path) != 1 || path[0] != "LockID" {
+		return "", fmt.Errorf("key condition must be on LockID")
+	}
+
+	if err := p.expect(tokenOperator, "="); err != nil {
+		return "", err
+	}
+
+	t := p.next()
+	if t.kind != tokenValue {
+		return "", fmt.Errorf("expected attribute value placeholder, got %q", t.text)
+	}
+	value, ok := values[t.text]
+	if !ok {
+		return "", fmt.Errorf("undefined attribute value placeholder %s", t.text)
+	}
+
+	return value.S, p.done()
+}
+
+// parseProjection parses a ProjectionExpression into the document paths it
+// lists, resolving #name placeholders.
+func parseProjection(expression string, names map[string]string) ([][]string, error) {
+
+	p, err := newExpressionParser(expression, names, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths [][]string
+	for {
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+
+		if t := p.peek(); t.kind != tokenPunct || t.text != "," {
+			break
+		}
+		p.next()
+	}
+
+	return paths, p.done()
+}
+
+// project keeps only the attributes designated by paths. Attributes are plain
+// strings, so a nested path never matches anything.
+func project(attributes map[string]string, paths [][]string) map[string]string {
+
+	if paths == nil {
+		return attributes
+	}
+
+	result := map[string]string{}
+	for _, path := range paths {
+		if len(path) != 1 {
+			continue
+		}
+
+		if value, ok := attributes[path[0]]; ok {
+			result[path[0]] = value
+		}
+	}
+
+	return result
+}
+
+// parseUpdate parses an UpdateExpression made of SET and REMOVE clauses into
+// the attributes to set and the attributes to remove. Values can only be
+// assigned from :value placeholders, and LockID cannot be updated.
+func parseUpdate(expression string, names map[string]string, values map[string]Item) (map[string]string, []string, error) {
+
+	p, err := newExpressionParser(expression, names, values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set := map[string]string{}
+	var remove []string
+	seen := map[string]bool{}
+	for p.peek().kind != tokenEOF {
+		clause := strings.ToUpper(p.next().text)
+		if clause != "SET" && clause != "REMOVE" {
+			return nil, nil, fmt.Errorf("unsupported update clause %q", clause)
+		}
+		if seen[clause] {
+			return nil, nil, fmt.Errorf("%s clause specified more than once", clause)
+		}
+		seen[clause] = true
+
+		for {
+			path, err := p.path()
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(path) != 1 {
+				return nil, nil, fmt.Errorf("nested attributes cannot be updated")
+			}
+			if path[0] == "LockID" {
+				return nil, nil, fmt.Errorf("LockID is part of the key and cannot be updated")
+			}
+
+			if clause == "SET" {
+				if err := p.expect(tokenOperator, "="); err != nil {
+					return nil, nil, err
+				}
+				t := p.next()
+				if t.kind != tokenValue {
+					return nil, nil, fmt.Errorf("expected attribute value placeholder, got %q", t.text)
+				}
+				value, ok := values[t.text]
+				if !ok {
+					return nil, nil, fmt.Errorf("undefined attribute value placeholder %s", t.text)
+				}
+				set[path[0]] = value.S
+			} else {
+				remove = append(remove, path[0])
+			}
+
+			if t := p.peek(); t.kind != tokenPunct || t.text != "," {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if len(seen) == 0 {
+		return nil, nil, fmt.Errorf("update expression is empty")
+	}
+
+	return set, remove, nil
+}
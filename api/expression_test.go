@@ -0,0 +1,179 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+
+	attributes := map[string]string{
+		"LockID": "tfstates/network",
+		"Info":   `{"Operation":"OperationTypeApply"}`,
+	}
+	names := map[string]string{
+		"#id": "LockID",
+	}
+	values := map[string]Item{
+		":prefix": {S: "tfstates/"},
+		":other":  {S: "tfstates/dns"},
+		":apply":  {S: "OperationTypeApply"},
+	}
+
+	cases := []struct {
+		expression  string
+		expectedErr bool
+		expected    bool
+	}{
+		{expression: "attribute_exists(LockID)", expected: true},
+		{expression: "attribute_not_exists(LockID)", expected: false},
+		{expression: "attribute_not_exists(Digest)", expected: true},
+		{expression: "begins_with(#id, :prefix)", expected: true},
+		{expression: "#id = :other", expected: false},
+		{expression: "#id <> :other", expected: true},
+		{expression: "#id > :other", expected: true},
+		{expression: "#id BETWEEN :prefix AND :other", expected: false},
+		{expression: "#id IN (:other, :prefix)", expected: false},
+		{expression: "contains(Info, :apply) AND NOT #id = :other", expected: true},
+		{expression: "#id = :other OR (begins_with(#id, :prefix) AND attribute_exists(Info))", expected: true},
+		{expression: "Info.Operation = :apply", expected: false},
+		{expression: "#missing = :other", expectedErr: true},
+		{expression: "LockID = :missing", expectedErr: true},
+		{expression: "LockID = ", expectedErr: true},
+		{expression: "size(Info) > :other", expectedErr: true},
+		{expression: "attribute_exists(LockID) extra", expectedErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expression, func(t *testing.T) {
+			condition, err := parseCondition(c.expression, names, values)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("Expected error: %v, got %v", c.expectedErr, err)
+			} else if err != nil {
+				return
+			}
+
+			if got := condition(attributes); got != c.expected {
+				t.Errorf("Expected %v, got %v", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseKeyCondition(t *testing.T) {
+
+	values := map[string]Item{
+		":id": {S: "tfstates/network"},
+	}
+
+	lockID, err := parseKeyCondition("#k = :id", map[string]string{"#k": "LockID"}, values)
+	if err != nil {
+		t.Fatalf("Error parsing key condition: %v", err)
+	}
+
+	if lockID != "tfstates/network" {
+		t.Errorf("Expected: %v\nGot: %v", "tfstates/network", lockID)
+	}
+
+	_, err = parseKeyCondition("Info = :id", nil, values)
+	if err == nil {
+		t.Errorf("Expected an error for a key condition not on LockID")
+	}
+}
+
+func TestParseProjection(t *testing.T) {
+
+	attributes := map[string]string{
+		"LockID": "tfstates/network",
+		"Info":   "Test",
+		"Digest": "d41d8cd98f00b204e9800998ecf8427e",
+	}
+
+	cases := []struct {
+		expression  string
+		names       map[string]string
+		expectedErr bool
+		expected    map[string]string
+	}{
+		{
+			expression: "LockID,Info",
+			expected:   map[string]string{"LockID": "tfstates/network", "Info": "Test"},
+		},
+		{
+			expression: "#d, Missing",
+			names:      map[string]string{"#d": "Digest"},
+			expected:   map[string]string{"Digest": "d41d8cd98f00b204e9800998ecf8427e"},
+		},
+		{
+			expression: "Info.Who, Items[0].Name",
+			expected:   map[string]string{},
+		},
+		{
+			expression:  "LockID,",
+			expectedErr: true,
+		},
+		{
+			expression:  "#undefined",
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expression, func(t *testing.T) {
+			paths, err := parseProjection(c.expression, c.names)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("Expected error: %v, got %v", c.expectedErr, err)
+			} else if err != nil {
+				return
+			}
+
+			if got := project(attributes, paths); !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("Expected %v, got %v", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+
+	values := map[string]Item{
+		":who": {S: "pablo"},
+	}
+
+	cases := []struct {
+		expression     string
+		expectedErr    bool
+		expectedSet    map[string]string
+		expectedRemove []string
+	}{
+		{
+			expression:  "SET Who = :who",
+			expectedSet: map[string]string{"Who": "pablo"},
+		},
+		{
+			expression:     "REMOVE Info, Digest SET #w = :who",
+			expectedSet:    map[string]string{"Who": "pablo"},
+			expectedRemove: []string{"Info", "Digest"},
+		},
+		{expression: "SET LockID = :who", expectedErr: true},
+		{expression: "SET Who = :who SET Info = :who", expectedErr: true},
+		{expression: "ADD Count :who", expectedErr: true},
+		{expression: "SET Who = Info", expectedErr: true},
+		{expression: "", expectedErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expression, func(t *testing.T) {
+			set, remove, err := parseUpdate(c.expression, map[string]string{"#w": "Who"}, values)
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("Expected error: %v, got %v", c.expectedErr, err)
+			} else if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(set, c.expectedSet) || !reflect.DeepEqual(remove, c.expectedRemove) {
+				t.Errorf("Expected %v %v, got %v %v", c.expectedSet, c.expectedRemove, set, remove)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+// FencingTokenHeader carries the fencing token assigned to a lock in the
+// response to its acquisition, through PutItem or the http backend.
+const FencingTokenHeader = "X-Locker-Fencing-Token"
+
+// setFencingToken sets the fencing token header of the response to the
+// acquisition of entry, if it has one.
+func setFencingToken(w http.ResponseWriter, entry *store.Entry) {
+	if token := entry.GetAttributes()[store.FencingTokenAttribute]; token != "" {
+		w.Header().Set(FencingTokenHeader, token)
+	}
+}
+
+type FencingCheckResponse struct {
+	LockID       string `json:"LockID"`
+	FencingToken string `json:"FencingToken,omitempty"`
+	Current      bool   `json:"Current"`
+}
+
+// fencingRouter lets downstream systems check that a fencing token is still
+// current, that is that the lock it was issued with is still held. It
+// answers 200 when it is and 409 otherwise, along with the current token.
+// Every request must carry token as a bearer token.
+func fencingRouter(s store.Store, token string) http.Handler {
+
+	r := chi.NewRouter()
+	r.Use(requireBearer(token))
+	r.Get("/{table}/*", func(w http.ResponseWriter, r *http.Request) {
+		handleCheckFencingToken(w, r, s)
+	})
+
+	return r
+}
+
+func handleCheckFencingToken(w http.ResponseWriter, r *http.Request, s store.Store) {
+
+	table, lockID := chi.URLParam(r, "table"), chi.URLParam(r, "*")
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Token is missing"))
+		return
+	}
+
+	entry, err := s.Get(r.Context(), table, lockID)
+	if err != nil && !errors.Is(err, store.ErrEntryNotFound) && !errors.Is(err, store.ErrTableNotFound) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Getting lock: %v", err)
+		return
+	}
+
+	resp := FencingCheckResponse{LockID: lockID, FencingToken: entry.GetAttributes()[store.FencingTokenAttribute]}
+	resp.Current = resp.FencingToken == token
+
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Current {
+		log.Printf("Fencing token %s of %s is stale", token, lockID)
+		w.WriteHeader(http.StatusConflict)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.Write(respJSON)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+func TestCheckFencingToken(t *testing.T) {
+
+	ctx := context.Background()
+	s := store.NewInMemoryStore()
+	server := httptest.NewServer(fencingRouter(s, "secret"))
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		_, _, err := s.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}, store.NotExists)
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if i == 0 {
+			_, err = s.Delete(ctx, "terraform-lock-table", "tfstates/network", nil)
+			if err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+		}
+	}
+
+	cases := []struct {
+		name           string
+		target         string
+		bearer         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "current token",
+			target:         "/terraform-lock-table/tfstates/network?token=2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"LockID":"tfstates/network","FencingToken":"2","Current":true}`,
+		},
+		{
+			name:           "stale token",
+			target:         "/terraform-lock-table/tfstates/network?token=1",
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"LockID":"tfstates/network","FencingToken":"2","Current":false}`,
+		},
+		{
+			name:           "lock not held",
+			target:         "/terraform-lock-table/tfstates/dns?token=1",
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"LockID":"tfstates/dns","Current":false}`,
+		},
+		{
+			name:           "unauthorized",
+			target:         "/terraform-lock-table/tfstates/network?token=2",
+			bearer:         "other",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   "Unauthorized",
+		},
+		{
+			name:           "missing token",
+			target:         "/terraform-lock-table/tfstates/network",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Token is missing",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bearer := c.bearer
+			if bearer == "" {
+				bearer = "secret"
+			}
+			req, err := http.NewRequest("GET", server.URL+c.target, nil)
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+bearer)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+
+			if resp.StatusCode != c.expectedStatus || string(body) != c.expectedBody {
+				t.Errorf("Expected %d %s, got %d %s", c.expectedStatus, c.expectedBody, resp.StatusCode, body)
+			}
+		})
+	}
+}
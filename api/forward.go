@@ -0,0 +1,182 @@
+package api
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// forwardedHeader marks a request forwarded by a follower, so that it is
+// never forwarded twice. It carries the cluster token, and is ignored on
+// requests that do not.
+const forwardedHeader = "X-Locker-Forwarded"
+
+// Cluster tells a node of a replicated store where its leader is.
+type Cluster interface {
+	IsLeader() bool
+	// LeaderAPI returns the base URL of the API of the leader, empty when
+	// it is not known.
+	LeaderAPI() string
+}
+
+// forwardToLeader proxies a DynamoDB request to the leader when this node is
+// a follower and the request needs the leader: writes, transactional reads
+// and consistent reads. It reports whether it handled the request. The
+// Authorization header, and so the caller identity, is passed along with
+// the request ID. A 503 that the AWS SDKs retry is returned when the leader
+// cannot be reached.
+func forwardToLeader(w http.ResponseWriter, r *http.Request, cluster Cluster, token string) bool {
+
+	if cluster.IsLeader() || forwardedByNode(r, token) {
+		return false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		return true
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if !needsLeader(r.Header.Get("X-Amz-Target"), body) {
+		return false
+	}
+
+	proxyToLeader(w, r, cluster, token, func(w http.ResponseWriter, message string) {
+		writeError(w, http.StatusServiceUnavailable, ErrorResponse{Type: "ServiceUnavailable", Message: message})
+	})
+
+	return true
+}
+
+// forwardWritesToLeader proxies the requests of a router other than GET and
+// HEAD to the leader when this node is a follower, so that they go through
+// the change freezes and wait queue of the leader like DynamoDB writes.
+func forwardWritesToLeader(cluster Cluster, token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if r.Method == http.MethodGet || r.Method == http.MethodHead || cluster.IsLeader() || forwardedByNode(r, token) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			proxyToLeader(w, r, cluster, token, func(w http.ResponseWriter, message string) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(message))
+			})
+		})
+	}
+}
+
+// forwardedByNode reports whether r was forwarded by another node of the
+// cluster. Clients must not be able to keep a request on a follower, so the
+// header is dropped when it does not carry token.
+func forwardedByNode(r *http.Request, token string) bool {
+
+	forwarded := r.Header.Get(forwardedHeader)
+	if forwarded == "" {
+		return false
+	}
+
+	if token == "" || subtle.ConstantTimeCompare([]byte(forwarded), []byte(token)) != 1 {
+		r.Header.Del(forwardedHeader)
+		return false
+	}
+
+	return true
+}
+
+// proxyToLeader sends r to the leader, answering with unavailable when it is
+// not known or cannot be reached.
+func proxyToLeader(w http.ResponseWriter, r *http.Request, cluster Cluster, token string, unavailable func(w http.ResponseWriter, message string)) {
+
+	leader, err := url.Parse(cluster.LeaderAPI())
+	if err != nil || leader.Host == "" {
+		unavailable(w, "No leader is available, retry later")
+		log.Printf("Forwarding %s %s: no leader", r.Method, r.URL.Path)
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(leader)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		unavailable(w, "The leader cannot be reached, retry later")
+		log.Printf("Forwarding to %s: %v", leader, err)
+	}
+
+	r.Header.Set(forwardedHeader, token)
+	r.Header.Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+	proxy.ServeHTTP(w, r)
+}
+
+// rejectOnFollower refuses a change to state that only the leader holds, in
+// memory, and reports whether it did.
+func rejectOnFollower(w http.ResponseWriter, cluster Cluster) bool {
+
+	if cluster == nil || cluster.IsLeader() {
+		return false
+	}
+
+	message := "This node is not the leader, send the request to the leader"
+	if leader := cluster.LeaderAPI(); leader != "" {
+		message += " at " + leader
+	}
+	w.WriteHeader(http.StatusMisdirectedRequest)
+	w.Write([]byte(message))
+
+	return true
+}
+
+// needsLeader reports whether a DynamoDB request must be served by the
+// leader.
+func needsLeader(target string, body []byte) bool {
+
+	if isWrite(target) {
+		return true
+	}
+
+	switch target {
+	case "DynamoDB_20120810.TransactGetItems":
+		return true
+	case "DynamoDB_20120810.GetItem", "DynamoDB_20120810.Scan", "DynamoDB_20120810.Query":
+		var req struct {
+			ConsistentRead bool `json:"ConsistentRead"`
+		}
+		json.Unmarshal(body, &req)
+		return req.ConsistentRead
+	case "DynamoDB_20120810.BatchGetItem":
+		var req struct {
+			RequestItems map[string]struct {
+				ConsistentRead bool `json:"ConsistentRead"`
+			} `json:"RequestItems"`
+		}
+		json.Unmarshal(body, &req)
+		for _, keys := range req.RequestItems {
+			if keys.ConsistentRead {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isWrite reports whether a DynamoDB request writes to the store.
+func isWrite(target string) bool {
+
+	switch target {
+	case "DynamoDB_20120810.PutItem", "DynamoDB_20120810.UpdateItem", "DynamoDB_20120810.DeleteItem",
+		"DynamoDB_20120810.BatchWriteItem", "DynamoDB_20120810.TransactWriteItems":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+type follower struct {
+	leaderAPI string
+}
+
+func (f follower) IsLeader() bool    { return false }
+func (f follower) LeaderAPI() string { return f.leaderAPI }
+
+func TestForwardToLeader(t *testing.T) {
+
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Amz-Target") + " " + r.Header.Get("Authorization") + " " + r.Header.Get("X-Request-Id")))
+	}))
+	defer leader.Close()
+
+	cases := []struct {
+		name           string
+		leaderAPI      string
+		target         string
+		forwarded      string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "forward a write",
+			leaderAPI:      leader.URL,
+			target:         "DynamoDB_20120810.PutItem",
+			body:           `{"TableName":"terraform-lock-table","Item":{"LockID":{"S":"tfstates/network"}}}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "DynamoDB_20120810.PutItem AWS4-HMAC-SHA256 Credential=AKIDALICE/20230101/us-east-1/dynamodb/aws4_request request-1",
+		},
+		{
+			name:           "forward a consistent read",
+			leaderAPI:      leader.URL,
+			target:         "DynamoDB_20120810.GetItem",
+			body:           `{"TableName":"terraform-lock-table","Key":{"LockID":{"S":"tfstates/network"}},"ConsistentRead":true}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "DynamoDB_20120810.GetItem AWS4-HMAC-SHA256 Credential=AKIDALICE/20230101/us-east-1/dynamodb/aws4_request request-1",
+		},
+		{
+			name:           "serve an eventually consistent read locally",
+			leaderAPI:      leader.URL,
+			target:         "DynamoDB_20120810.GetItem",
+			body:           `{"TableName":"terraform-lock-table","Key":{"LockID":{"S":"tfstates/network"}}}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{}`,
+		},
+		{
+			name:           "forwarded header from a client",
+			leaderAPI:      leader.URL,
+			target:         "DynamoDB_20120810.PutItem",
+			forwarded:      "true",
+			body:           `{"TableName":"terraform-lock-table","Item":{"LockID":{"S":"tfstates/network"}}}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "DynamoDB_20120810.PutItem AWS4-HMAC-SHA256 Credential=AKIDALICE/20230101/us-east-1/dynamodb/aws4_request request-1",
+		},
+		{
+			name:           "forwarded by another node",
+			leaderAPI:      leader.URL,
+			target:         "DynamoDB_20120810.PutItem",
+			forwarded:      "cluster",
+			body:           `{"TableName":"terraform-lock-table","Item":{"LockID":{"S":"tfstates/network"}}}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{}`,
+		},
+		{
+			name:           "no leader",
+			target:         "DynamoDB_20120810.DeleteItem",
+			body:           `{"TableName":"terraform-lock-table","Key":{"LockID":{"S":"tfstates/network"}}}`,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"__type":"com.amazonaws.dynamodb.v20120810#ServiceUnavailable","message":"No leader is available, retry later"}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(newRouter(store.NewInMemoryStore(), Options{Cluster: follower{leaderAPI: c.leaderAPI}, ClusterToken: "cluster"}))
+			defer server.Close()
+
+			req, err := http.NewRequest("POST", server.URL+"/", strings.NewReader(c.body))
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			req.Header.Set("X-Amz-Target", c.target)
+			req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIDALICE/20230101/us-east-1/dynamodb/aws4_request")
+			req.Header.Set("X-Request-Id", "request-1")
+			if c.forwarded != "" {
+				req.Header.Set(forwardedHeader, c.forwarded)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+
+			if resp.StatusCode != c.expectedStatus || string(body) != c.expectedBody {
+				t.Errorf("Expected %d %s, got %d %s", c.expectedStatus, c.expectedBody, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestFollowerLeaderState(t *testing.T) {
+
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method + " " + r.URL.Path))
+	}))
+	defer leader.Close()
+
+	server := httptest.NewServer(newRouter(store.NewInMemoryStore(), Options{
+		Cluster:        follower{leaderAPI: leader.URL},
+		ClusterToken:   "cluster",
+		AdminToken:     "secret",
+		HTTPLockTable:  "terraform-lock-table",
+		HTTPStateTable: "terraform-http-state",
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "forward a lock through the http backend",
+			method:         "LOCK",
+			target:         "/state/tfstates/network",
+			body:           `{"ID":"2f5b4b3e-93a8-4d8a-9d1e-4b8e3c1a2b3c"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "LOCK /state/tfstates/network",
+		},
+		{
+			name:           "read a state locally",
+			method:         "GET",
+			target:         "/state/tfstates/network",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "Not found",
+		},
+		{
+			name:           "add a change freeze on a follower",
+			method:         "POST",
+			target:         "/admin/freezes",
+			body:           `{"End":"2100-01-01T00:00:00Z"}`,
+			expectedStatus: http.StatusMisdirectedRequest,
+			expectedBody:   "This node is not the leader, send the request to the leader at " + leader.URL,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, server.URL+c.target, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			req.Header.Set("Authorization", "Bearer secret")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+
+			if resp.StatusCode != c.expectedStatus || string(body) != c.expectedBody {
+				t.Errorf("Expected %d %s, got %d %s", c.expectedStatus, c.expectedBody, resp.StatusCode, body)
+			}
+		})
+	}
+}
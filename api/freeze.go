@@ -0,0 +1,228 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FreezeWindow blocks the acquisition of matching locks between Start and
+// End. An empty Table, LockIDs or Operations matches everything. LockIDs are
+// globs as understood by path.Match, so * does not match a /. Operations are
+// matched against the Operation of the lock info, such as
+// OperationTypeApply.
+type FreezeWindow struct {
+	ID         string    `json:"ID"`
+	Table      string    `json:"Table,omitempty"`
+	LockIDs    []string  `json:"LockIDs,omitempty"`
+	Operations []string  `json:"Operations,omitempty"`
+	Start      time.Time `json:"Start"`
+	End        time.Time `json:"End"`
+	Reason     string    `json:"Reason,omitempty"`
+}
+
+// ErrDuplicateFreeze is returned when a freeze window is added with the ID of
+// another one.
+var ErrDuplicateFreeze = errors.New("freeze window already exists")
+
+// ParseFreezeWindows parses a JSON list of freeze windows.
+func ParseFreezeWindows(body io.Reader) ([]FreezeWindow, error) {
+
+	var windows []FreezeWindow
+	err := json.NewDecoder(body).Decode(&windows)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[string]bool{}
+	for _, window := range windows {
+		err = window.validate()
+		if err != nil {
+			return nil, err
+		}
+
+		if window.ID != "" && ids[window.ID] {
+			return nil, fmt.Errorf("freeze window %q: %w", window.ID, ErrDuplicateFreeze)
+		}
+		ids[window.ID] = true
+	}
+
+	return windows, nil
+}
+
+func (f FreezeWindow) validate() error {
+
+	if f.End.IsZero() || !f.End.After(f.Start) {
+		return fmt.Errorf("freeze window %q must end after it starts", f.ID)
+	}
+
+	for _, pattern := range f.LockIDs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("freeze window %q: invalid LockID glob %q", f.ID, pattern)
+		}
+	}
+
+	return nil
+}
+
+// matches reports whether the window blocks the acquisition of lockID in
+// table for operation at now.
+func (f FreezeWindow) matches(table, lockID, operation string, now time.Time) bool {
+
+	if now.Before(f.Start) || !now.Before(f.End) {
+		return false
+	}
+
+	if f.Table != "" && f.Table != table {
+		return false
+	}
+
+	if len(f.LockIDs) > 0 && !matchAny(f.LockIDs, lockID, func(pattern, lockID string) bool {
+		ok, _ := path.Match(pattern, lockID)
+		return ok
+	}) {
+		return false
+	}
+
+	if len(f.Operations) > 0 && !matchAny(f.Operations, operation, func(a, b string) bool { return a == b }) {
+		return false
+	}
+
+	return true
+}
+
+func matchAny(patterns []string, value string, match func(pattern, value string) bool) bool {
+	for _, pattern := range patterns {
+		if match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// freezeSchedule holds the scheduled windows given at startup along with the
+// ad-hoc ones added through the admin API.
+type freezeSchedule struct {
+	mu       sync.Mutex
+	sequence int
+	windows  []FreezeWindow
+}
+
+func newFreezeSchedule(windows []FreezeWindow) *freezeSchedule {
+
+	f := &freezeSchedule{}
+	for _, window := range windows {
+		_, err := f.add(window)
+		if err != nil {
+			log.Printf("Ignoring change freeze: %v", err)
+		}
+	}
+
+	return f
+}
+
+// add schedules a window, assigning it an ID if it has none, and returns it.
+// Generated IDs skip the ones already given.
+func (f *freezeSchedule) add(window FreezeWindow) (FreezeWindow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if window.ID != "" && f.exists(window.ID) {
+		return FreezeWindow{}, fmt.Errorf("freeze window %q: %w", window.ID, ErrDuplicateFreeze)
+	}
+
+	for window.ID == "" {
+		f.sequence++
+		if id := "freeze-" + strconv.Itoa(f.sequence); !f.exists(id) {
+			window.ID = id
+		}
+	}
+	f.windows = append(f.windows, window)
+
+	return window, nil
+}
+
+// exists reports whether a window has id. The caller must hold f.mu.
+func (f *freezeSchedule) exists(id string) bool {
+	for _, window := range f.windows {
+		if window.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// remove cancels a window and reports whether it existed.
+func (f *freezeSchedule) remove(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, window := range f.windows {
+		if window.ID == id {
+			f.windows = append(f.windows[:i:i], f.windows[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// list returns the windows that have not ended yet, sorted by start.
+func (f *freezeSchedule) list() []FreezeWindow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	windows := []FreezeWindow{}
+	for _, window := range f.windows {
+		if now.Before(window.End) {
+			windows = append(windows, window)
+		}
+	}
+
+	sort.SliceStable(windows, func(i, j int) bool {
+		return windows[i].Start.Before(windows[j].Start)
+	})
+
+	return windows
+}
+
+// frozen returns the window blocking the acquisition of lockID in table for
+// operation, the one ending last when several do, or nil.
+func (f *freezeSchedule) frozen(table, lockID, operation string) *FreezeWindow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	var result *FreezeWindow
+	for i, window := range f.windows {
+		if window.matches(table, lockID, operation, now) && (result == nil || window.End.After(result.End)) {
+			result = &f.windows[i]
+		}
+	}
+
+	if result == nil {
+		return nil
+	}
+	window := *result
+
+	return &window
+}
+
+// message explains a freeze to the Terraform user whose lock is refused.
+func (f FreezeWindow) message(lockID string) string {
+
+	message := fmt.Sprintf("Change freeze %s in effect on %s until %s", f.ID, lockID, f.End.UTC().Format(time.RFC3339))
+	if f.Reason != "" {
+		message += ": " + f.Reason
+	}
+
+	return message
+}
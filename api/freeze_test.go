@@ -0,0 +1,312 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+func TestFreezeWindowMatches(t *testing.T) {
+
+	now := time.Date(2023, 12, 20, 12, 0, 0, 0, time.UTC)
+	window := FreezeWindow{
+		ID:         "release",
+		Table:      "terraform-lock-table",
+		LockIDs:    []string{"tfstates/prod/*"},
+		Operations: []string{"OperationTypeApply"},
+		Start:      now.Add(-time.Hour),
+		End:        now.Add(time.Hour),
+	}
+
+	cases := []struct {
+		name      string
+		table     string
+		lockID    string
+		operation string
+		now       time.Time
+		expected  bool
+	}{
+		{
+			name:      "apply on a production stack",
+			table:     "terraform-lock-table",
+			lockID:    "tfstates/prod/network",
+			operation: "OperationTypeApply",
+			now:       now,
+			expected:  true,
+		},
+		{
+			name:      "plan on a production stack",
+			table:     "terraform-lock-table",
+			lockID:    "tfstates/prod/network",
+			operation: "OperationTypePlan",
+			now:       now,
+			expected:  false,
+		},
+		{
+			name:      "apply on a staging stack",
+			table:     "terraform-lock-table",
+			lockID:    "tfstates/staging/network",
+			operation: "OperationTypeApply",
+			now:       now,
+			expected:  false,
+		},
+		{
+			name:      "apply in another table",
+			table:     "other-table",
+			lockID:    "tfstates/prod/network",
+			operation: "OperationTypeApply",
+			now:       now,
+			expected:  false,
+		},
+		{
+			name:      "apply after the freeze",
+			table:     "terraform-lock-table",
+			lockID:    "tfstates/prod/network",
+			operation: "OperationTypeApply",
+			now:       window.End,
+			expected:  false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if matches := window.matches(c.table, c.lockID, c.operation, c.now); matches != c.expected {
+				t.Errorf("Expected %v, got %v", c.expected, matches)
+			}
+		})
+	}
+}
+
+func TestFreezeScheduleAdd(t *testing.T) {
+
+	end := time.Now().Add(time.Hour)
+	f := newFreezeSchedule([]FreezeWindow{{ID: "freeze-2", End: end}})
+
+	cases := []struct {
+		name        string
+		id          string
+		expectedID  string
+		expectedErr error
+	}{
+		{
+			name:       "generated ID",
+			expectedID: "freeze-1",
+		},
+		{
+			name:       "generated ID skips the IDs given",
+			expectedID: "freeze-3",
+		},
+		{
+			name:        "duplicate ID",
+			id:          "freeze-1",
+			expectedErr: ErrDuplicateFreeze,
+		},
+		{
+			name:       "given ID",
+			id:         "release",
+			expectedID: "release",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			window, err := f.add(FreezeWindow{ID: c.id, End: end})
+			if !errors.Is(err, c.expectedErr) {
+				t.Errorf("Expected error %v, got %v", c.expectedErr, err)
+			}
+			if window.ID != c.expectedID {
+				t.Errorf("Expected %q, got %q", c.expectedID, window.ID)
+			}
+		})
+	}
+}
+
+func TestFreezeAcquisitions(t *testing.T) {
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	end := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	server := httptest.NewServer(newRouter(store.NewInMemoryStore(), Options{
+		HTTPLockTable:  "terraform-lock-table",
+		HTTPStateTable: "terraform-http-state",
+		FreezeWindows: []FreezeWindow{{
+			ID:      "release",
+			Table:   "terraform-lock-table",
+			LockIDs: []string{"tfstates/prod/*"},
+			Start:   start,
+			End:     end,
+		}},
+	}))
+	defer server.Close()
+
+	message := "Change freeze release in effect on tfstates/prod/network until " + end.Format(time.RFC3339)
+	denied := `{"__type":"com.amazonaws.dynamodb.v20120810#AccessDeniedException","message":"` + message + `"}`
+	info := `{"ID":"2f5b4b3e-93a8-4d8a-9d1e-4b8e3c1a2b3c","Operation":"OperationTypeApply"}`
+
+	cases := []struct {
+		name           string
+		method         string
+		target         string
+		header         string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "put item",
+			method:         "POST",
+			target:         "/",
+			header:         "DynamoDB_20120810.PutItem",
+			body:           `{"TableName":"terraform-lock-table","Item":{"LockID":{"S":"tfstates/prod/network"}},"ConditionExpression":"attribute_not_exists(LockID)"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   denied,
+		},
+		{
+			name:           "unconditional put item",
+			method:         "POST",
+			target:         "/",
+			header:         "DynamoDB_20120810.PutItem",
+			body:           `{"TableName":"terraform-lock-table","Item":{"LockID":{"S":"tfstates/prod/network"}}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   denied,
+		},
+		{
+			name:           "transact write items",
+			method:         "POST",
+			target:         "/",
+			header:         "DynamoDB_20120810.TransactWriteItems",
+			body:           `{"TransactItems":[{"Put":{"TableName":"terraform-lock-table","Item":{"LockID":{"S":"tfstates/prod/network"}},"ConditionExpression":"attribute_not_exists(LockID)"}}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   denied,
+		},
+		{
+			name:           "batch write item",
+			method:         "POST",
+			target:         "/",
+			header:         "DynamoDB_20120810.BatchWriteItem",
+			body:           `{"RequestItems":{"terraform-lock-table":[{"PutRequest":{"Item":{"LockID":{"S":"tfstates/prod/network"}}}}]}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   denied,
+		},
+		{
+			name:           "lock",
+			method:         "LOCK",
+			target:         "/state/tfstates/prod/network",
+			body:           info,
+			expectedStatus: http.StatusLocked,
+			expectedBody:   `{"ID":"release","Operation":"","Info":"` + message + `","Who":"","Version":"","Created":"` + start.Format(time.RFC3339) + `","Path":"tfstates/prod/network"}`,
+		},
+		{
+			name:           "lock outside the freeze",
+			method:         "LOCK",
+			target:         "/state/tfstates/staging/network",
+			body:           info,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, server.URL+c.target, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			req.Header.Set("X-Amz-Target", c.header)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+
+			if resp.StatusCode != c.expectedStatus || string(body) != c.expectedBody {
+				t.Errorf("Expected %d %s, got %d %s", c.expectedStatus, c.expectedBody, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestFreezeReplayedTransaction(t *testing.T) {
+
+	server := httptest.NewServer(newRouter(store.NewInMemoryStore(), Options{
+		HTTPLockTable:  "terraform-lock-table",
+		HTTPStateTable: "terraform-http-state",
+		AdminToken:     "secret",
+	}))
+	defer server.Close()
+
+	transact := `{"ClientRequestToken":"apply-1","TransactItems":[{"Put":{"TableName":"terraform-lock-table","Item":{"LockID":{"S":"tfstates/prod/network"}},"ConditionExpression":"attribute_not_exists(LockID)"}}]}`
+	other := `{"ClientRequestToken":"apply-2","TransactItems":[{"Put":{"TableName":"terraform-lock-table","Item":{"LockID":{"S":"tfstates/prod/dns"}},"ConditionExpression":"attribute_not_exists(LockID)"}}]}`
+
+	cases := []struct {
+		name           string
+		target         string
+		header         string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "transaction",
+			target:         "/",
+			header:         "DynamoDB_20120810.TransactWriteItems",
+			body:           transact,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "freeze",
+			target:         "/admin/freezes",
+			body:           `{"ID":"incident","Table":"terraform-lock-table","LockIDs":["tfstates/prod/*"],"End":"` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "retried transaction",
+			target:         "/",
+			header:         "DynamoDB_20120810.TransactWriteItems",
+			body:           transact,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "other transaction",
+			target:         "/",
+			header:         "DynamoDB_20120810.TransactWriteItems",
+			body:           other,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", server.URL+c.target, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			req.Header.Set("X-Amz-Target", c.header)
+			req.Header.Set("Authorization", "Bearer secret")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+
+			if resp.StatusCode != c.expectedStatus {
+				t.Errorf("Expected %d, got %d %s", c.expectedStatus, resp.StatusCode, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+// ErrLockQueued is returned by guard.check when other callers wait for the
+// lock ahead of the caller.
+var ErrLockQueued = errors.New("another caller waits for the lock")
+
+// FreezeError is returned by guard.check when a change freeze blocks the
+// acquisition.
+type FreezeError struct {
+	Window FreezeWindow
+	LockID string
+}
+
+func (e *FreezeError) Error() string {
+	return e.Window.message(e.LockID)
+}
+
+// acquisition is the creation of a lock entry by a caller, whatever the API
+// it comes through. operation is the Operation of its lock info.
+type acquisition struct {
+	key       store.Key
+	waiter    Waiter
+	operation string
+}
+
+func newAcquisition(r *http.Request, table, lockID string, attributes map[string]string) acquisition {
+
+	lockInfo, _ := ParseLockInfo(attributes["Info"])
+
+	return acquisition{
+		key:       store.Key{Table: table, ID: lockID},
+		waiter:    requestWaiter(r, attributes),
+		operation: lockInfo.Operation,
+	}
+}
+
+// guard runs the checks every acquisition goes through, so that none of the
+// APIs bypasses them. queue is nil unless fairness mode is enabled.
+type guard struct {
+	queue   *waitQueue
+	freezes *freezeSchedule
+}
+
+// check returns a *FreezeError when a is blocked by a change freeze and
+// ErrLockQueued when it must not be attempted yet.
+func (g *guard) check(a acquisition) error {
+
+	if window := g.freezes.frozen(a.key.Table, a.key.ID, a.operation); window != nil {
+		log.Printf("Refusing %s of %s: change freeze %s", a.operation, a.key.ID, window.ID)
+		return &FreezeError{Window: *window, LockID: a.key.ID}
+	}
+
+	// In fairness mode, acquisitions are reserved to the caller that has
+	// waited the longest
+	if g.queued(a) && !g.queue.admit(a.key, a.waiter) {
+		g.queue.wait(a.key, a.waiter)
+		return ErrLockQueued
+	}
+
+	return nil
+}
+
+// done records the outcome of an attempted acquisition, queueing the caller
+// when the lock was held.
+func (g *guard) done(a acquisition, acquired bool) {
+
+	if !g.queued(a) {
+		return
+	}
+
+	if acquired {
+		g.queue.done(a.key, a.waiter)
+		return
+	}
+	g.queue.wait(a.key, a.waiter)
+}
+
+func (g *guard) queued(a acquisition) bool {
+	return g.queue != nil && a.waiter.ID != ""
+}
@@ -1,15 +1,23 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"reflect"
 	"regexp"
+	"strings"
 
 	"github.com/pablo-ruth/terraform-state-locker/store"
+	"github.com/pablo-ruth/terraform-state-locker/webhook"
 )
 
-func handlePutItem(w http.ResponseWriter, r *http.Request, s store.Store) {
+func handlePutItem(w http.ResponseWriter, r *http.Request, s store.Store, opts Options, guard *guard) {
 
 	putItemRequest, err := ParsePutItemRequest(r.Body)
 	if err != nil {
@@ -48,6 +56,10 @@ func handlePutItem(w http.ResponseWriter, r *http.Request, s store.Store) {
 		return
 	}
 
+	if !validateReturnValues(w, putItemRequest.ReturnValues, putItemRequest.ReturnValuesOnConditionCheckFailure) {
+		return
+	}
+
 	attributes := map[string]string{}
 	for k, v := range putItemRequest.Item {
 		if k == "LockID" {
@@ -57,11 +69,47 @@ func handlePutItem(w http.ResponseWriter, r *http.Request, s store.Store) {
 		attributes[k] = v.S
 	}
 
-	err = s.Put(putItemRequest.TableName, lockID.S, notExists, attributes)
+	// A put creating a lock is an acquisition, conditional or not
+	acquiring := notExists
+	if !acquiring && store.Kind(lockID.S) == store.KindLock {
+		existing, err := s.Get(r.Context(), putItemRequest.TableName, lockID.S)
+		if err != nil && !errors.Is(err, store.ErrEntryNotFound) && !errors.Is(err, store.ErrTableNotFound) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Internal server error"))
+			log.Printf("Getting lock: %v", err)
+			return
+		}
+		acquiring = existing == nil
+	}
+
+	acquisition := newAcquisition(r, putItemRequest.TableName, lockID.S, attributes)
+	if acquiring {
+		err = guard.check(acquisition)
+		var frozen *FreezeError
+		if errors.As(err, &frozen) {
+			writeFrozen(w, frozen)
+			return
+		}
+		if err != nil {
+			current, _ := s.Get(r.Context(), putItemRequest.TableName, lockID.S)
+			notify(opts.Webhooks, webhook.Conflict, putItemRequest.TableName, lockID.S, callerIdentity(r), current.GetAttributes()["Info"])
+			writeConflict(w, lockID.S, current.GetAttributes(), putItemRequest.ReturnValuesOnConditionCheckFailure)
+			return
+		}
+	}
+
+	var condition store.Condition
+	if notExists {
+		condition = store.NotExists
+	}
+	old, current, err := s.Put(r.Context(), putItemRequest.TableName, store.Item{ID: lockID.S, Attributes: attributes, Owner: callerIdentity(r)}, condition)
 	if err != nil {
-		if err == store.ErrEntryAlreadyExists {
-			w.WriteHeader(http.StatusConflict)
-			w.Write([]byte("Conflict"))
+		if errors.Is(err, store.ErrConditionalCheckFailed) {
+			if acquiring {
+				guard.done(acquisition, false)
+			}
+			notify(opts.Webhooks, webhook.Conflict, putItemRequest.TableName, lockID.S, callerIdentity(r), old.GetAttributes()["Info"])
+			writeConflict(w, lockID.S, old.GetAttributes(), putItemRequest.ReturnValuesOnConditionCheckFailure)
 			return
 		}
 
@@ -71,8 +119,39 @@ func handlePutItem(w http.ResponseWriter, r *http.Request, s store.Store) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{}`))
+	if acquiring {
+		guard.done(acquisition, true)
+		notify(opts.Webhooks, webhook.Acquire, putItemRequest.TableName, lockID.S, callerIdentity(r), attributes["Info"])
+	}
+
+	setFencingToken(w, current)
+	writeWriteItemResponse(w, lockID.S, old.GetAttributes(), putItemRequest.ReturnValues)
+}
+
+// writeFrozen refuses an acquisition blocked by a change freeze.
+func writeFrozen(w http.ResponseWriter, frozen *FreezeError) {
+	writeError(w, http.StatusBadRequest, ErrorResponse{
+		Type:    "AccessDeniedException",
+		Message: frozen.Error(),
+	})
+}
+
+// writeConflict answers a failed acquisition. The current holder is returned
+// along with the conflict so that the client does not have to race a GetItem
+// to find it.
+func writeConflict(w http.ResponseWriter, lockID string, current map[string]string, returnValuesOnConditionCheckFailure string) {
+
+	if returnValuesOnConditionCheckFailure == "ALL_OLD" {
+		writeError(w, http.StatusBadRequest, ErrorResponse{
+			Type:    "ConditionalCheckFailedException",
+			Message: "The conditional request failed",
+			Item:    toItem(lockID, current, nil),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusConflict)
+	w.Write([]byte("Conflict"))
 }
 
 func handleGetItem(w http.ResponseWriter, r *http.Request, s store.Store) {
@@ -92,9 +171,16 @@ func handleGetItem(w http.ResponseWriter, r *http.Request, s store.Store) {
 		return
 	}
 
-	values, err := s.Get(getItemRequest.TableName, lockID.S)
+	projection, ok := parseProjectionExpression(w, getItemRequest.ProjectionExpression, getItemRequest.ExpressionAttributeNames)
+	if !ok {
+		return
+	}
+
+	// Consistent reads reach this point on the leader only, followers
+	// forwarding them, so the local store is always good enough.
+	entry, err := s.Get(r.Context(), getItemRequest.TableName, lockID.S)
 	if err != nil {
-		if err == store.ErrEntryNotFound || err == store.ErrTableNotFound {
+		if errors.Is(err, store.ErrEntryNotFound) || errors.Is(err, store.ErrTableNotFound) {
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte(`{}`))
 			log.Printf("Entry not found: %v", err)
@@ -108,14 +194,7 @@ func handleGetItem(w http.ResponseWriter, r *http.Request, s store.Store) {
 	}
 
 	var resp GetItemResponse
-	resp.Item = map[string]Item{}
-	for k, v := range values {
-		if k == "LockID" {
-			continue
-		}
-
-		resp.Item[k] = Item{S: v}
-	}
+	resp.Item = toItem(lockID.S, entry.Attributes, projection)
 
 	itemJSON, err := json.Marshal(resp)
 	if err != nil {
@@ -128,7 +207,202 @@ func handleGetItem(w http.ResponseWriter, r *http.Request, s store.Store) {
 	w.Write([]byte(itemJSON))
 }
 
-func handleDeleteItem(w http.ResponseWriter, r *http.Request, s store.Store) {
+// updateRetries bounds the attempts of an UpdateItem whose item keeps
+// changing between the read it is decided on and its write.
+const updateRetries = 10
+
+// handleUpdateItem sets and removes attributes of an item, creating it when
+// it does not exist. With ReturnValues ALL_NEW the item written is returned
+// along with its fencing token, so that SDKs acquiring a lock with
+// UpdateItem get the token without reading the fencing token header.
+func handleUpdateItem(w http.ResponseWriter, r *http.Request, s store.Store, opts Options, guard *guard) {
+
+	updateItemRequest, err := ParseUpdateItemRequest(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	lockID, ok := updateItemRequest.Key["LockID"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("LockID is missing"))
+		return
+	}
+
+	returnValues := updateItemRequest.ReturnValues
+	if returnValues != "" && returnValues != "NONE" && returnValues != "ALL_OLD" && returnValues != "ALL_NEW" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("ReturnValues must be NONE, ALL_OLD or ALL_NEW"))
+		return
+	}
+	if !validateReturnValues(w, "", updateItemRequest.ReturnValuesOnConditionCheckFailure) {
+		return
+	}
+
+	var expressionCondition store.Condition
+	if updateItemRequest.ConditionExpression != "" {
+		c, err := parseCondition(updateItemRequest.ConditionExpression, updateItemRequest.ExpressionAttributeNames, updateItemRequest.ExpressionAttributeValues)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid condition expression"))
+			log.Printf("Parsing condition expression: %v", err)
+			return
+		}
+		expressionCondition = storeCondition(c)
+	}
+
+	set, remove, err := parseUpdate(updateItemRequest.UpdateExpression, updateItemRequest.ExpressionAttributeNames, updateItemRequest.ExpressionAttributeValues)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid update expression"))
+		log.Printf("Parsing update expression: %v", err)
+		return
+	}
+
+	table := updateItemRequest.TableName
+	caller := callerIdentity(r)
+	conditionFailed := func(current *store.Entry, message string) {
+		resp := ErrorResponse{
+			Type:    "ConditionalCheckFailedException",
+			Message: message,
+		}
+		if updateItemRequest.ReturnValuesOnConditionCheckFailure == "ALL_OLD" && current != nil {
+			resp.Item = toItem(lockID.S, current.Attributes, nil)
+		}
+		writeError(w, http.StatusBadRequest, resp)
+	}
+
+	// An update creating a lock is an acquisition, like a put
+	var acquiring bool
+	if store.Kind(lockID.S) == store.KindLock {
+		existing, err := s.Get(r.Context(), table, lockID.S)
+		if err != nil && !errors.Is(err, store.ErrEntryNotFound) && !errors.Is(err, store.ErrTableNotFound) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Internal server error"))
+			log.Printf("Getting lock: %v", err)
+			return
+		}
+		acquiring = existing == nil
+	}
+
+	acquisition := newAcquisition(r, table, lockID.S, set)
+	if acquiring {
+		err = guard.check(acquisition)
+		var frozen *FreezeError
+		if errors.As(err, &frozen) {
+			writeFrozen(w, frozen)
+			return
+		}
+		if err != nil {
+			current, _ := s.Get(r.Context(), table, lockID.S)
+			notify(opts.Webhooks, webhook.Conflict, table, lockID.S, caller, current.GetAttributes()["Info"])
+			conditionFailed(current, "The conditional request failed")
+			return
+		}
+	}
+
+	var notOwner bool
+	condition := store.ConditionFunc(func(entry *store.Entry) bool {
+		if opts.OwnerCheck && !isOwner(entry, caller) {
+			notOwner = true
+			return false
+		}
+		return expressionCondition == nil || expressionCondition.Holds(entry)
+	})
+
+	old, current, err := updateEntry(r.Context(), s, table, lockID.S, set, remove, caller, condition)
+	if err != nil {
+		if errors.Is(err, store.ErrConditionalCheckFailed) {
+			if acquiring {
+				guard.done(acquisition, false)
+				notify(opts.Webhooks, webhook.Conflict, table, lockID.S, caller, old.GetAttributes()["Info"])
+			}
+			message := "The conditional request failed"
+			if notOwner {
+				message = "The lock is held by another identity"
+				log.Printf("Refusing update of %s by %q: held by another identity", lockID.S, caller)
+			}
+			conditionFailed(old, message)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Updating lock: %v", err)
+		return
+	}
+
+	if acquiring {
+		guard.done(acquisition, true)
+		notify(opts.Webhooks, webhook.Acquire, table, lockID.S, caller, current.Attributes["Info"])
+	}
+
+	setFencingToken(w, current)
+	values := old.GetAttributes()
+	if returnValues == "ALL_NEW" {
+		values = current.GetAttributes()
+	}
+	writeWriteItemResponse(w, lockID.S, values, returnValues)
+}
+
+// updateEntry sets and removes attributes of the entry read, creating it
+// owned by caller if needed, and writes it only if it did not change in the
+// meantime, trying again otherwise. When condition does not hold, the
+// current entry is returned with ErrConditionalCheckFailed.
+func updateEntry(ctx context.Context, s store.Store, table, id string, set map[string]string, remove []string, caller string, condition store.Condition) (*store.Entry, *store.Entry, error) {
+
+	for i := 0; i < updateRetries; i++ {
+		existing, err := s.Get(ctx, table, id)
+		if err != nil && !errors.Is(err, store.ErrEntryNotFound) && !errors.Is(err, store.ErrTableNotFound) {
+			return nil, nil, err
+		}
+
+		item := store.Item{ID: id, Attributes: map[string]string{}, Owner: caller}
+		if existing != nil {
+			for k, v := range existing.Attributes {
+				item.Attributes[k] = v
+			}
+			item.Owner = existing.Owner
+		}
+		for k, v := range set {
+			item.Attributes[k] = v
+		}
+		for _, k := range remove {
+			delete(item.Attributes, k)
+		}
+
+		var changed bool
+		old, current, err := s.Put(ctx, table, item, store.ConditionFunc(func(entry *store.Entry) bool {
+			if !sameEntry(entry, existing) {
+				changed = true
+				return false
+			}
+			return condition.Holds(entry)
+		}))
+		if changed {
+			continue
+		}
+
+		return old, current, err
+	}
+
+	return nil, nil, fmt.Errorf("%s in %s kept changing", id, table)
+}
+
+// sameEntry reports whether a and b have the same attributes and owner.
+func sameEntry(a, b *store.Entry) bool {
+
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return a.Owner == b.Owner && reflect.DeepEqual(a.Attributes, b.Attributes)
+}
+
+func handleDeleteItem(w http.ResponseWriter, r *http.Request, s store.Store, opts Options) {
 
 	deleteItemRequest, err := ParseDeleteItemRequest(r.Body)
 	if err != nil {
@@ -145,10 +419,60 @@ func handleDeleteItem(w http.ResponseWriter, r *http.Request, s store.Store) {
 		return
 	}
 
-	err = s.Delete(deleteItemRequest.TableName, lockID.S)
+	if !validateReturnValues(w, deleteItemRequest.ReturnValues, deleteItemRequest.ReturnValuesOnConditionCheckFailure) {
+		return
+	}
+
+	var deleteCondition store.Condition
+	if deleteItemRequest.ConditionExpression != "" {
+		c, err := parseCondition(deleteItemRequest.ConditionExpression, deleteItemRequest.ExpressionAttributeNames, deleteItemRequest.ExpressionAttributeValues)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid condition expression"))
+			log.Printf("Parsing condition expression: %v", err)
+			return
+		}
+		deleteCondition = storeCondition(c)
+	}
+
+	// The holder is recorded to tell a release from a forced unlock by
+	// another identity
+	caller := callerIdentity(r)
+	var notOwner bool
+	var holder string
+	expressionCondition := deleteCondition
+	deleteCondition = store.ConditionFunc(func(entry *store.Entry) bool {
+		if entry != nil {
+			holder = entry.Owner
+		}
+		if opts.OwnerCheck && !isOwner(entry, caller) {
+			notOwner = true
+			return false
+		}
+		return expressionCondition == nil || expressionCondition.Holds(entry)
+	})
+
+	old, err := s.Delete(r.Context(), deleteItemRequest.TableName, lockID.S, deleteCondition)
 	if err != nil {
 
-		if err == store.ErrEntryNotFound || err == store.ErrTableNotFound {
+		if errors.Is(err, store.ErrConditionalCheckFailed) {
+			resp := ErrorResponse{
+				Type:    "ConditionalCheckFailedException",
+				Message: "The conditional request failed",
+			}
+			if notOwner {
+				resp.Message = "The lock is held by another identity"
+				log.Printf("Refusing release of %s by %q: held by another identity", lockID.S, caller)
+			}
+			if deleteItemRequest.ReturnValuesOnConditionCheckFailure == "ALL_OLD" && old != nil {
+				resp.Item = toItem(lockID.S, old.GetAttributes(), nil)
+			}
+
+			writeError(w, http.StatusBadRequest, resp)
+			return
+		}
+
+		if errors.Is(err, store.ErrEntryNotFound) || errors.Is(err, store.ErrTableNotFound) {
 			w.WriteHeader(http.StatusNotFound)
 			w.Write([]byte("Not found"))
 			return
@@ -160,6 +484,889 @@ func handleDeleteItem(w http.ResponseWriter, r *http.Request, s store.Store) {
 		return
 	}
 
+	if holder != "" && caller != "" && holder != caller {
+		notify(opts.Webhooks, webhook.ForceUnlock, deleteItemRequest.TableName, lockID.S, caller, old.Attributes["Info"])
+	} else {
+		notify(opts.Webhooks, webhook.Release, deleteItemRequest.TableName, lockID.S, caller, old.Attributes["Info"])
+	}
+
+	writeWriteItemResponse(w, lockID.S, old.Attributes, deleteItemRequest.ReturnValues)
+}
+
+// validateReturnValues checks the ReturnValues options of a write, writing
+// the error response itself when they are not supported.
+func validateReturnValues(w http.ResponseWriter, returnValues, returnValuesOnConditionCheckFailure string) bool {
+
+	if returnValues != "" && returnValues != "NONE" && returnValues != "ALL_OLD" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("ReturnValues must be NONE or ALL_OLD"))
+		return false
+	}
+
+	if returnValuesOnConditionCheckFailure != "" && returnValuesOnConditionCheckFailure != "NONE" && returnValuesOnConditionCheckFailure != "ALL_OLD" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("ReturnValuesOnConditionCheckFailure must be NONE or ALL_OLD"))
+		return false
+	}
+
+	return true
+}
+
+// writeWriteItemResponse answers a write, values being the previous item for
+// ALL_OLD and the item written for ALL_NEW.
+func writeWriteItemResponse(w http.ResponseWriter, lockID string, values map[string]string, returnValues string) {
+
+	var resp WriteItemResponse
+	if (returnValues == "ALL_OLD" || returnValues == "ALL_NEW") && values != nil {
+		resp.Attributes = toItem(lockID, values, nil)
+	}
+
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{}`))
+	w.Write(respJSON)
+}
+
+func handleScan(w http.ResponseWriter, r *http.Request, s store.Store) {
+
+	scanRequest, err := ParseScanRequest(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	if scanRequest.Limit < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Limit must be positive"))
+		return
+	}
+
+	filter, ok := parseFilter(w, scanRequest.FilterExpression, scanRequest.ExpressionAttributeNames, scanRequest.ExpressionAttributeValues)
+	if !ok {
+		return
+	}
+
+	projection, ok := parseProjectionExpression(w, scanRequest.ProjectionExpression, scanRequest.ExpressionAttributeNames)
+	if !ok {
+		return
+	}
+
+	var exclusiveStartID string
+	if scanRequest.ExclusiveStartKey != nil {
+		lockID, ok := scanRequest.ExclusiveStartKey["LockID"]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("LockID is missing from ExclusiveStartKey"))
+			return
+		}
+		exclusiveStartID = lockID.S
+	}
+
+	// Fetch one more entry than requested to know whether there is a next page
+	var fetch int
+	if scanRequest.Limit > 0 {
+		fetch = scanRequest.Limit + 1
+	}
+
+	entries, err := s.Scan(r.Context(), scanRequest.TableName, exclusiveStartID, fetch)
+	if err != nil && !errors.Is(err, store.ErrTableNotFound) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Scanning table: %v", err)
+		return
+	}
+
+	var lastEvaluatedKey map[string]Item
+	if scanRequest.Limit > 0 && len(entries) > scanRequest.Limit {
+		entries = entries[:scanRequest.Limit]
+		lastEvaluatedKey = map[string]Item{"LockID": {S: entries[len(entries)-1].ID}}
+	}
+
+	writeScanResponse(w, entries, filter, projection, scanRequest.Select, lastEvaluatedKey)
+}
+
+func handleQuery(w http.ResponseWriter, r *http.Request, s store.Store) {
+
+	queryRequest, err := ParseQueryRequest(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	if queryRequest.Limit < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Limit must be positive"))
+		return
+	}
+
+	lockID, err := parseKeyCondition(queryRequest.KeyConditionExpression, queryRequest.ExpressionAttributeNames, queryRequest.ExpressionAttributeValues)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid key condition expression"))
+		log.Printf("Parsing key condition expression: %v", err)
+		return
+	}
+
+	filter, ok := parseFilter(w, queryRequest.FilterExpression, queryRequest.ExpressionAttributeNames, queryRequest.ExpressionAttributeValues)
+	if !ok {
+		return
+	}
+
+	projection, ok := parseProjectionExpression(w, queryRequest.ProjectionExpression, queryRequest.ExpressionAttributeNames)
+	if !ok {
+		return
+	}
+
+	// LockID is the only key of the table, so a query matches at most one
+	// entry and a start key means that entry was already returned
+	var entries []store.Entry
+	if queryRequest.ExclusiveStartKey == nil {
+		entry, err := s.Get(r.Context(), queryRequest.TableName, lockID)
+		if err != nil && !errors.Is(err, store.ErrEntryNotFound) && !errors.Is(err, store.ErrTableNotFound) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Internal server error"))
+			log.Printf("Querying lock: %v", err)
+			return
+		}
+
+		if err == nil {
+			entries = append(entries, *entry)
+		}
+	}
+
+	writeScanResponse(w, entries, filter, projection, queryRequest.Select, nil)
+}
+
+// parseFilter compiles an optional filter expression, writing the error
+// response itself when the expression is invalid.
+func parseFilter(w http.ResponseWriter, expression string, names map[string]string, values map[string]Item) (condition, bool) {
+
+	if expression == "" {
+		return nil, true
+	}
+
+	filter, err := parseCondition(expression, names, values)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid filter expression"))
+		log.Printf("Parsing filter expression: %v", err)
+		return nil, false
+	}
+
+	return filter, true
+}
+
+// parseProjectionExpression parses an optional projection expression,
+// writing the error response itself when the expression is invalid.
+func parseProjectionExpression(w http.ResponseWriter, expression string, names map[string]string) ([][]string, bool) {
+
+	if expression == "" {
+		return nil, true
+	}
+
+	projection, err := parseProjection(expression, names)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid projection expression"))
+		log.Printf("Parsing projection expression: %v", err)
+		return nil, false
+	}
+
+	return projection, true
+}
+
+func writeScanResponse(w http.ResponseWriter, entries []store.Entry, filter condition, projection [][]string, selectAttributes string, lastEvaluatedKey map[string]Item) {
+
+	switch selectAttributes {
+	case "", "ALL_ATTRIBUTES", "SPECIFIC_ATTRIBUTES", "COUNT":
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Select must be ALL_ATTRIBUTES, SPECIFIC_ATTRIBUTES or COUNT"))
+		return
+	}
+
+	resp := ScanResponse{
+		ScannedCount:     len(entries),
+		LastEvaluatedKey: lastEvaluatedKey,
+	}
+	for _, entry := range entries {
+		attributes := map[string]string{"LockID": entry.ID}
+		for k, v := range entry.Attributes {
+			attributes[k] = v
+		}
+
+		if filter != nil && !filter(attributes) {
+			continue
+		}
+
+		resp.Count++
+		if selectAttributes == "COUNT" {
+			continue
+		}
+
+		resp.Items = append(resp.Items, toItem(entry.ID, entry.Attributes, projection))
+	}
+
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
+
+const (
+	maxBatchGetKeys    = 100
+	maxBatchWriteItems = 25
+)
+
+func handleBatchGetItem(w http.ResponseWriter, r *http.Request, s store.Store) {
+
+	batchGetItemRequest, err := ParseBatchGetItemRequest(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	if len(batchGetItemRequest.RequestItems) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("RequestItems is empty"))
+		return
+	}
+
+	var count int
+	projections := map[string][][]string{}
+	for table, keysAndAttributes := range batchGetItemRequest.RequestItems {
+		if len(keysAndAttributes.Keys) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Keys is empty"))
+			return
+		}
+		count += len(keysAndAttributes.Keys)
+
+		if !validateBatchKeys(w, keysAndAttributes.Keys) {
+			return
+		}
+
+		projection, ok := parseProjectionExpression(w, keysAndAttributes.ProjectionExpression, keysAndAttributes.ExpressionAttributeNames)
+		if !ok {
+			return
+		}
+		projections[table] = projection
+	}
+
+	if count > maxBatchGetKeys {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Too many items requested for the BatchGetItem call"))
+		return
+	}
+
+	resp := BatchGetItemResponse{
+		Responses:       map[string][]map[string]Item{},
+		UnprocessedKeys: map[string]KeysAndAttributes{},
+	}
+	for table, keysAndAttributes := range batchGetItemRequest.RequestItems {
+		resp.Responses[table] = []map[string]Item{}
+		for _, key := range keysAndAttributes.Keys {
+			lockID := key["LockID"].S
+			entry, err := s.Get(r.Context(), table, lockID)
+			if errors.Is(err, store.ErrEntryNotFound) || errors.Is(err, store.ErrTableNotFound) {
+				continue
+			}
+
+			// Keys that could not be read are handed back for the client to retry
+			if err != nil {
+				log.Printf("Getting lock: %v", err)
+				unprocessed, ok := resp.UnprocessedKeys[table]
+				if !ok {
+					unprocessed = keysAndAttributes
+					unprocessed.Keys = nil
+				}
+				unprocessed.Keys = append(unprocessed.Keys, key)
+				resp.UnprocessedKeys[table] = unprocessed
+				continue
+			}
+
+			resp.Responses[table] = append(resp.Responses[table], toItem(lockID, entry.Attributes, projections[table]))
+		}
+	}
+
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
+
+func handleBatchWriteItem(w http.ResponseWriter, r *http.Request, s store.Store, opts Options, guard *guard) {
+
+	batchWriteItemRequest, err := ParseBatchWriteItemRequest(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	if len(batchWriteItemRequest.RequestItems) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("RequestItems is empty"))
+		return
+	}
+
+	var count int
+	for _, writeRequests := range batchWriteItemRequest.RequestItems {
+		if len(writeRequests) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Write requests are empty"))
+			return
+		}
+		count += len(writeRequests)
+
+		keys := make([]map[string]Item, 0, len(writeRequests))
+		for _, writeRequest := range writeRequests {
+			switch {
+			case writeRequest.PutRequest != nil && writeRequest.DeleteRequest == nil:
+				keys = append(keys, writeRequest.PutRequest.Item)
+			case writeRequest.DeleteRequest != nil && writeRequest.PutRequest == nil:
+				keys = append(keys, writeRequest.DeleteRequest.Key)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Write request must contain exactly one of PutRequest or DeleteRequest"))
+				return
+			}
+		}
+
+		if !validateBatchKeys(w, keys) {
+			return
+		}
+	}
+
+	if count > maxBatchWriteItems {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Too many items requested for the BatchWriteItem call"))
+		return
+	}
+
+	// Writes that can never succeed fail the whole request before anything
+	// is written, clients would retry them forever if handed back as
+	// unprocessed
+	caller := callerIdentity(r)
+	acquisitions := map[store.Key]acquisition{}
+	for table, writeRequests := range batchWriteItemRequest.RequestItems {
+		for _, writeRequest := range writeRequests {
+			lockID := batchWriteLockID(writeRequest)
+			entry, err := s.Get(r.Context(), table, lockID)
+			if errors.Is(err, store.ErrTableNotFound) && writeRequest.DeleteRequest != nil {
+				writeError(w, http.StatusBadRequest, ErrorResponse{Type: "ResourceNotFoundException", Message: "Requested resource not found"})
+				return
+			}
+			if err != nil && !errors.Is(err, store.ErrEntryNotFound) && !errors.Is(err, store.ErrTableNotFound) {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Internal server error"))
+				log.Printf("Getting lock: %v", err)
+				return
+			}
+
+			if opts.OwnerCheck && !isOwner(entry, caller) {
+				writeOwnerConflict(w, lockID, caller)
+				return
+			}
+
+			// A put creating a lock is an acquisition
+			if writeRequest.PutRequest != nil && entry == nil && store.Kind(lockID) == store.KindLock {
+				a := newAcquisition(r, table, lockID, map[string]string{"Info": writeRequest.PutRequest.Item["Info"].S})
+				err = guard.check(a)
+				var frozen *FreezeError
+				if errors.As(err, &frozen) {
+					writeFrozen(w, frozen)
+					return
+				}
+				if err != nil {
+					writeError(w, http.StatusBadRequest, ErrorResponse{
+						Type:    "ConditionalCheckFailedException",
+						Message: "Other callers wait for the lock",
+					})
+					log.Printf("Refusing write of %s by %q: other callers wait for the lock", lockID, caller)
+					return
+				}
+				acquisitions[a.key] = a
+			}
+		}
+	}
+
+	var condition store.Condition
+	if opts.OwnerCheck {
+		condition = store.ConditionFunc(func(entry *store.Entry) bool { return isOwner(entry, caller) })
+	}
+
+	resp := BatchWriteItemResponse{
+		UnprocessedItems: map[string][]WriteRequest{},
+	}
+	for table, writeRequests := range batchWriteItemRequest.RequestItems {
+		for _, writeRequest := range writeRequests {
+			lockID := batchWriteLockID(writeRequest)
+
+			var err error
+			if writeRequest.PutRequest != nil {
+				attributes := map[string]string{}
+				for k, v := range writeRequest.PutRequest.Item {
+					if k == "LockID" {
+						continue
+					}
+
+					attributes[k] = v.S
+				}
+
+				_, _, err = s.Put(r.Context(), table, store.Item{ID: lockID, Attributes: attributes, Owner: caller}, condition)
+				if a, ok := acquisitions[store.Key{Table: table, ID: lockID}]; ok && err == nil {
+					guard.done(a, true)
+				}
+			} else {
+				_, err = s.Delete(r.Context(), table, lockID, condition)
+				if errors.Is(err, store.ErrEntryNotFound) {
+					err = nil
+				}
+			}
+
+			// The lock was taken by another identity since it was checked,
+			// the writes already made are kept as BatchWriteItem is not
+			// atomic
+			if errors.Is(err, store.ErrConditionalCheckFailed) {
+				writeOwnerConflict(w, lockID, caller)
+				return
+			}
+			if errors.Is(err, store.ErrTableNotFound) {
+				writeError(w, http.StatusBadRequest, ErrorResponse{Type: "ResourceNotFoundException", Message: "Requested resource not found"})
+				return
+			}
+
+			// Writes that failed otherwise are handed back for the client
+			// to retry
+			if err != nil {
+				log.Printf("Writing lock: %v", err)
+				resp.UnprocessedItems[table] = append(resp.UnprocessedItems[table], writeRequest)
+			}
+		}
+	}
+
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
+
+// batchWriteLockID returns the LockID written by writeRequest.
+func batchWriteLockID(writeRequest WriteRequest) string {
+	if writeRequest.PutRequest != nil {
+		return writeRequest.PutRequest.Item["LockID"].S
+	}
+	return writeRequest.DeleteRequest.Key["LockID"].S
+}
+
+// writeOwnerConflict refuses a write to a lock held by another identity.
+func writeOwnerConflict(w http.ResponseWriter, lockID, caller string) {
+	writeError(w, http.StatusBadRequest, ErrorResponse{
+		Type:    "ConditionalCheckFailedException",
+		Message: "The lock is held by another identity",
+	})
+	log.Printf("Refusing write of %s by %q: held by another identity", lockID, caller)
+}
+
+// validateBatchKeys checks that every key of a batch names a LockID and that
+// no LockID appears twice, writing the error response itself otherwise.
+func validateBatchKeys(w http.ResponseWriter, keys []map[string]Item) bool {
+
+	seen := map[string]bool{}
+	for _, key := range keys {
+		lockID, ok := key["LockID"]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("LockID is missing"))
+			return false
+		}
+
+		if seen[lockID.S] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provided list of item keys contains duplicates"))
+			return false
+		}
+		seen[lockID.S] = true
+	}
+
+	return true
+}
+
+// toItem builds the response item of an entry, LockID included, keeping only
+// the projected attributes when a projection is given.
+func toItem(lockID string, values map[string]string, projection [][]string) map[string]Item {
+
+	attributes := map[string]string{"LockID": lockID}
+	for k, v := range values {
+		attributes[k] = v
+	}
+
+	item := map[string]Item{}
+	for k, v := range project(attributes, projection) {
+		item[k] = Item{S: v}
+	}
+
+	return item
+}
+
+const maxTransactItems = 100
+
+func handleTransactWriteItems(w http.ResponseWriter, r *http.Request, s store.Store, opts Options, tokens *tokenCache, guard *guard) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Reading request: %v", err)
+		return
+	}
+
+	transactWriteItemsRequest, err := ParseTransactWriteItemsRequest(bytes.NewReader(body))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	if len(transactWriteItemsRequest.TransactItems) == 0 || len(transactWriteItemsRequest.TransactItems) > maxTransactItems {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("TransactItems must contain between 1 and 100 items"))
+		return
+	}
+
+	caller := callerIdentity(r)
+	ops := make([]store.WriteOp, 0, len(transactWriteItemsRequest.TransactItems))
+	returnOld := make([]bool, 0, len(transactWriteItemsRequest.TransactItems))
+	acquisitions := map[int]acquisition{}
+	seen := map[store.Key]bool{}
+	for _, transactItem := range transactWriteItemsRequest.TransactItems {
+		var op store.WriteOp
+		var operation *TransactWriteOperation
+		var count int
+		if transactItem.Put != nil {
+			op.Kind, operation = store.WritePut, transactItem.Put
+			count++
+		}
+		if transactItem.Update != nil {
+			op.Kind, operation = store.WriteUpdate, transactItem.Update
+			count++
+		}
+		if transactItem.Delete != nil {
+			op.Kind, operation = store.WriteDelete, transactItem.Delete
+			count++
+		}
+		if transactItem.ConditionCheck != nil {
+			op.Kind, operation = store.WriteConditionCheck, transactItem.ConditionCheck
+			count++
+		}
+		if count != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Transact item must contain exactly one of Put, Update, Delete or ConditionCheck"))
+			return
+		}
+
+		key := operation.Key
+		if op.Kind == store.WritePut {
+			key = operation.Item
+		}
+		lockID, ok := key["LockID"]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("LockID is missing"))
+			return
+		}
+
+		op.Table, op.ID = operation.TableName, lockID.S
+		if seen[store.Key{Table: op.Table, ID: op.ID}] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Transaction request cannot include multiple operations on one item"))
+			return
+		}
+		seen[store.Key{Table: op.Table, ID: op.ID}] = true
+
+		if operation.ConditionExpression != "" {
+			c, err := parseCondition(operation.ConditionExpression, operation.ExpressionAttributeNames, operation.ExpressionAttributeValues)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Invalid condition expression"))
+				log.Printf("Parsing condition expression: %v", err)
+				return
+			}
+			op.Condition = storeCondition(c)
+		} else if op.Kind == store.WriteConditionCheck {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("ConditionCheck requires a condition expression"))
+			return
+		}
+
+		switch op.Kind {
+		case store.WritePut:
+			op.Owner = caller
+			op.Attributes = map[string]string{}
+			for k, v := range operation.Item {
+				if k == "LockID" {
+					continue
+				}
+
+				op.Attributes[k] = v.S
+			}
+		case store.WriteUpdate:
+			op.Attributes, op.Remove, err = parseUpdate(operation.UpdateExpression, operation.ExpressionAttributeNames, operation.ExpressionAttributeValues)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Invalid update expression"))
+				log.Printf("Parsing update expression: %v", err)
+				return
+			}
+		}
+
+		if !validateReturnValues(w, "", operation.ReturnValuesOnConditionCheckFailure) {
+			return
+		}
+
+		// A conditional put of a lock that may not exist yet is an
+		// acquisition
+		if op.Kind == store.WritePut && store.Kind(op.ID) == store.KindLock && op.Condition != nil && op.Condition.Holds(nil) {
+			acquisitions[len(ops)] = newAcquisition(r, op.Table, op.ID, op.Attributes)
+		}
+
+		// Like BatchWriteItem, no write may replace or remove a lock held
+		// by another identity
+		if opts.OwnerCheck && op.Kind != store.WriteConditionCheck {
+			expressionCondition := op.Condition
+			op.Condition = store.ConditionFunc(func(entry *store.Entry) bool {
+				return isOwner(entry, caller) && (expressionCondition == nil || expressionCondition.Holds(entry))
+			})
+		}
+
+		ops = append(ops, op)
+		returnOld = append(returnOld, operation.ReturnValuesOnConditionCheckFailure == "ALL_OLD")
+	}
+
+	// Acquisitions that must not be attempted yet cancel the transaction as
+	// if their lock was held. They are checked once the ClientRequestToken
+	// is known not to be a retry, whose outcome stands.
+	var refused *store.TransactionCanceledError
+	apply := func() error {
+		for i, a := range acquisitions {
+			err := guard.check(a)
+			var frozen *FreezeError
+			if errors.As(err, &frozen) {
+				return frozen
+			}
+			if err != nil {
+				if refused == nil {
+					refused = &store.TransactionCanceledError{Reasons: make([]error, len(ops)), Items: make([]*store.Entry, len(ops))}
+				}
+				refused.Reasons[i] = store.ErrConditionalCheckFailed
+			}
+		}
+		if refused != nil {
+			return refused
+		}
+
+		return s.TransactWrite(r.Context(), ops)
+	}
+	var replayed bool
+	if token := transactWriteItemsRequest.ClientRequestToken; token != "" {
+		replayed, err = tokens.do(token, body, apply)
+	} else {
+		err = apply()
+	}
+
+	var frozen *FreezeError
+	if errors.As(err, &frozen) {
+		writeFrozen(w, frozen)
+		return
+	}
+
+	var canceled *store.TransactionCanceledError
+	errors.As(err, &canceled)
+	for i, a := range acquisitions {
+		switch {
+		case replayed:
+			// A retry attempts nothing
+		case err == nil:
+			guard.done(a, true)
+		case canceled != nil && canceled != refused && errors.Is(canceled.Reasons[i], store.ErrConditionalCheckFailed):
+			guard.done(a, false)
+		}
+	}
+
+	if err != nil {
+		if errors.As(err, &canceled) {
+			resp := ErrorResponse{
+				Type: "TransactionCanceledException",
+			}
+			var codes []string
+			for i, reason := range canceled.Reasons {
+				cancellationReason := CancellationReason{Code: "None"}
+				if errors.Is(reason, store.ErrConditionalCheckFailed) {
+					cancellationReason = CancellationReason{Code: "ConditionalCheckFailed", Message: "The conditional request failed"}
+					if returnOld[i] && canceled.Items[i] != nil {
+						cancellationReason.Item = toItem(ops[i].ID, canceled.Items[i].GetAttributes(), nil)
+					}
+				}
+				resp.CancellationReasons = append(resp.CancellationReasons, cancellationReason)
+				codes = append(codes, cancellationReason.Code)
+			}
+			resp.Message = fmt.Sprintf("Transaction cancelled, please refer cancellation reasons for specific reasons [%s]", strings.Join(codes, ", "))
+
+			writeError(w, http.StatusBadRequest, resp)
+			return
+		}
+
+		if errors.Is(err, ErrIdempotentParameterMismatch) {
+			writeError(w, http.StatusBadRequest, ErrorResponse{
+				Type:    "IdempotentParameterMismatchException",
+				Message: "Client token already used with different parameters",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Writing transaction: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{}`))
+}
+
+func handleTransactGetItems(w http.ResponseWriter, r *http.Request, s store.Store) {
+
+	transactGetItemsRequest, err := ParseTransactGetItemsRequest(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	if len(transactGetItemsRequest.TransactItems) == 0 || len(transactGetItemsRequest.TransactItems) > maxTransactItems {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("TransactItems must contain between 1 and 100 items"))
+		return
+	}
+
+	keys := make([]store.Key, 0, len(transactGetItemsRequest.TransactItems))
+	projections := make([][][]string, 0, len(transactGetItemsRequest.TransactItems))
+	for _, transactItem := range transactGetItemsRequest.TransactItems {
+		if transactItem.Get == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Transact item must contain Get"))
+			return
+		}
+
+		lockID, ok := transactItem.Get.Key["LockID"]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("LockID is missing"))
+			return
+		}
+
+		projection, ok := parseProjectionExpression(w, transactItem.Get.ProjectionExpression, transactItem.Get.ExpressionAttributeNames)
+		if !ok {
+			return
+		}
+
+		keys = append(keys, store.Key{Table: transactItem.Get.TableName, ID: lockID.S})
+		projections = append(projections, projection)
+	}
+
+	values, err := s.TransactGet(r.Context(), keys)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Reading transaction: %v", err)
+		return
+	}
+
+	var resp TransactGetItemsResponse
+	for i, key := range keys {
+		var itemResponse ItemResponse
+		if values[i] != nil {
+			itemResponse.Item = toItem(key.ID, values[i].GetAttributes(), projections[i])
+		}
+		resp.Responses = append(resp.Responses, itemResponse)
+	}
+
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
+
+// storeCondition adapts a parsed condition to a store condition, exposing the
+// LockID of the entry as an attribute.
+func storeCondition(c condition) store.Condition {
+
+	return store.ConditionFunc(func(entry *store.Entry) bool {
+		attributes := map[string]string{}
+		if entry != nil {
+			for k, v := range entry.Attributes {
+				attributes[k] = v
+			}
+			attributes["LockID"] = entry.ID
+		}
+
+		return c(attributes)
+	})
+}
+
+// isOwner reports whether caller may release entry in owner-checked release
+// mode: entries acquired by unsigned requests can be released by anyone.
+func isOwner(entry *store.Entry, caller string) bool {
+	return entry == nil || entry.Owner == "" || entry.Owner == caller
+}
+
+// writeError writes a DynamoDB error that the AWS SDKs can decode.
+func writeError(w http.ResponseWriter, status int, resp ErrorResponse) {
+
+	resp.Type = "com.amazonaws.dynamodb.v20120810#" + resp.Type
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(status)
+	w.Write(respJSON)
 }
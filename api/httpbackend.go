@@ -0,0 +1,248 @@
+package api
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/pablo-ruth/terraform-state-locker/store"
+	"github.com/pablo-ruth/terraform-state-locker/webhook"
+)
+
+func init() {
+	chi.RegisterMethod("LOCK")
+	chi.RegisterMethod("UNLOCK")
+}
+
+// httpBackendRouter serves Terraform's http backend protocol under
+// /state/<path>. Locks are stored in the same table as DynamoDB locks with
+// <path> as LockID, so an http backend at /state/<bucket>/<key> and an S3
+// backend on <bucket>/<key> exclude each other.
+func httpBackendRouter(s store.Store, opts Options, guard *guard) http.Handler {
+
+	r := chi.NewRouter()
+	if opts.Replication != nil {
+		r.Use(readOnlyOnReplica(opts.Replication))
+	}
+	if opts.Cluster != nil {
+		r.Use(forwardWritesToLeader(opts.Cluster, opts.ClusterToken))
+	}
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
+		handleGetState(w, r, s, opts)
+	})
+	r.Post("/*", func(w http.ResponseWriter, r *http.Request) {
+		handlePostState(w, r, s, opts)
+	})
+	r.Delete("/*", func(w http.ResponseWriter, r *http.Request) {
+		handleDeleteState(w, r, s, opts)
+	})
+	r.MethodFunc("LOCK", "/*", func(w http.ResponseWriter, r *http.Request) {
+		handleLockState(w, r, s, opts, guard)
+	})
+	r.MethodFunc("UNLOCK", "/*", func(w http.ResponseWriter, r *http.Request) {
+		handleUnlockState(w, r, s, opts)
+	})
+
+	return r
+}
+
+func handleGetState(w http.ResponseWriter, r *http.Request, s store.Store, opts Options) {
+
+	path := chi.URLParam(r, "*")
+	entry, err := s.Get(r.Context(), opts.HTTPStateTable, path)
+	if err != nil {
+		if errors.Is(err, store.ErrEntryNotFound) || errors.Is(err, store.ErrTableNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Not found"))
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Getting state: %v", err)
+		return
+	}
+
+	state := []byte(entry.Attributes["State"])
+	digest := md5.Sum(state)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(digest[:]))
+	w.WriteHeader(http.StatusOK)
+	w.Write(state)
+}
+
+func handlePostState(w http.ResponseWriter, r *http.Request, s store.Store, opts Options) {
+
+	path := chi.URLParam(r, "*")
+	state, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Reading state: %v", err)
+		return
+	}
+
+	// When locking is enabled, Terraform passes the ID of the lock it holds
+	if id := r.URL.Query().Get("ID"); id != "" {
+		entry, err := s.Get(r.Context(), opts.HTTPLockTable, path)
+		if err != nil && !errors.Is(err, store.ErrEntryNotFound) && !errors.Is(err, store.ErrTableNotFound) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Internal server error"))
+			log.Printf("Getting lock: %v", err)
+			return
+		}
+
+		if err != nil || lockInfoID(entry.Attributes["Info"]) != id {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(entry.GetAttributes()["Info"]))
+			log.Printf("Refusing state update of %s: lock %s is not held", path, id)
+			return
+		}
+	}
+
+	_, _, err = s.Put(r.Context(), opts.HTTPStateTable, store.Item{ID: path, Attributes: map[string]string{"State": string(state)}, Owner: callerIdentity(r)}, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Storing state: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleDeleteState(w http.ResponseWriter, r *http.Request, s store.Store, opts Options) {
+
+	path := chi.URLParam(r, "*")
+	_, err := s.Delete(r.Context(), opts.HTTPStateTable, path, nil)
+	if err != nil && !errors.Is(err, store.ErrEntryNotFound) && !errors.Is(err, store.ErrTableNotFound) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Deleting state: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleLockState(w http.ResponseWriter, r *http.Request, s store.Store, opts Options, guard *guard) {
+
+	path := chi.URLParam(r, "*")
+	info, err := io.ReadAll(r.Body)
+	if err != nil || lockInfoID(string(info)) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid lock info"))
+		log.Printf("Reading lock info: %v", err)
+		return
+	}
+
+	attributes := map[string]string{"Info": string(info)}
+	acquisition := newAcquisition(r, opts.HTTPLockTable, path, attributes)
+	err = guard.check(acquisition)
+	var frozen *FreezeError
+	if errors.As(err, &frozen) {
+		// Terraform shows the lock info of a 423 to the user
+		lockInfo, _ := json.Marshal(LockInfo{ID: frozen.Window.ID, Info: frozen.Error(), Created: frozen.Window.Start, Path: path})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusLocked)
+		w.Write(lockInfo)
+		return
+	}
+	if err != nil {
+		current, _ := s.Get(r.Context(), opts.HTTPLockTable, path)
+		notify(opts.Webhooks, webhook.Conflict, opts.HTTPLockTable, path, callerIdentity(r), current.GetAttributes()["Info"])
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusLocked)
+		w.Write([]byte(current.GetAttributes()["Info"]))
+		return
+	}
+
+	old, current, err := s.Put(r.Context(), opts.HTTPLockTable, store.Item{ID: path, Attributes: attributes, Owner: callerIdentity(r)}, store.NotExists)
+	if err != nil {
+		// Terraform reads the current lock info from the body of a 423
+		if errors.Is(err, store.ErrConditionalCheckFailed) {
+			guard.done(acquisition, false)
+			notify(opts.Webhooks, webhook.Conflict, opts.HTTPLockTable, path, callerIdentity(r), old.Attributes["Info"])
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusLocked)
+			w.Write([]byte(old.Attributes["Info"]))
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Storing lock: %v", err)
+		return
+	}
+	guard.done(acquisition, true)
+	notify(opts.Webhooks, webhook.Acquire, opts.HTTPLockTable, path, callerIdentity(r), string(info))
+
+	setFencingToken(w, current)
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleUnlockState(w http.ResponseWriter, r *http.Request, s store.Store, opts Options) {
+
+	path := chi.URLParam(r, "*")
+	info, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Reading lock info: %v", err)
+		return
+	}
+
+	// terraform force-unlock sends no lock info, any other unlock must match
+	// the ID of the lock being held
+	id := lockInfoID(string(info))
+	caller := callerIdentity(r)
+	unlockCondition := store.ConditionFunc(func(entry *store.Entry) bool {
+		if opts.OwnerCheck && !isOwner(entry, caller) {
+			return false
+		}
+		return entry == nil || id == "" || lockInfoID(entry.Attributes["Info"]) == id
+	})
+
+	old, err := s.Delete(r.Context(), opts.HTTPLockTable, path, unlockCondition)
+	if err != nil && !errors.Is(err, store.ErrEntryNotFound) && !errors.Is(err, store.ErrTableNotFound) {
+		if errors.Is(err, store.ErrConditionalCheckFailed) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(old.GetAttributes()["Info"]))
+			log.Printf("Refusing release of %s by %q: lock is held by someone else", path, caller)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Deleting lock: %v", err)
+		return
+	}
+
+	if err == nil {
+		eventType := webhook.Release
+		if id == "" {
+			eventType = webhook.ForceUnlock
+		}
+		notify(opts.Webhooks, eventType, opts.HTTPLockTable, path, caller, old.Attributes["Info"])
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// lockInfoID returns the ID of a Terraform lock info JSON document, or an
+// empty string if it cannot be decoded.
+func lockInfoID(info string) string {
+
+	lockInfo, err := ParseLockInfo(info)
+	if err != nil {
+		return ""
+	}
+
+	return lockInfo.ID
+}
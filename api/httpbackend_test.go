@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+func TestHTTPBackend(t *testing.T) {
+
+	ctx := context.Background()
+	s := store.NewInMemoryStore()
+	server := httptest.NewServer(httpBackendRouter(s, Options{
+		HTTPLockTable:  "terraform-lock-table",
+		HTTPStateTable: "terraform-http-state",
+	}, &guard{freezes: newFreezeSchedule(nil)}))
+	defer server.Close()
+
+	dynamoDBInfo := `{"ID":"bc4abeab-0f07-e6b0-8b6d-a68460074a8e","Operation":"OperationTypePlan"}`
+	httpInfo := `{"ID":"2f5b4b3e-93a8-4d8a-9d1e-4b8e3c1a2b3c","Operation":"OperationTypeApply"}`
+
+	cases := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		expectedStatus int
+		expectedBody   string
+		expectedToken  string
+	}{
+		{
+			name:           "lock held through DynamoDB",
+			method:         "LOCK",
+			target:         "/tfstates/network",
+			body:           httpInfo,
+			expectedStatus: http.StatusLocked,
+			expectedBody:   dynamoDBInfo,
+		},
+		{
+			name:           "unlock someone else's lock",
+			method:         "UNLOCK",
+			target:         "/tfstates/network",
+			body:           httpInfo,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   dynamoDBInfo,
+		},
+		{
+			name:           "force unlock",
+			method:         "UNLOCK",
+			target:         "/tfstates/network",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "lock",
+			method:         "LOCK",
+			target:         "/tfstates/network",
+			body:           httpInfo,
+			expectedStatus: http.StatusOK,
+			expectedToken:  "2",
+		},
+		{
+			name:           "save state without holding the lock",
+			method:         "POST",
+			target:         "/tfstates/network?ID=bc4abeab-0f07-e6b0-8b6d-a68460074a8e",
+			body:           `{"version":4}`,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   httpInfo,
+		},
+		{
+			name:           "save state",
+			method:         "POST",
+			target:         "/tfstates/network?ID=2f5b4b3e-93a8-4d8a-9d1e-4b8e3c1a2b3c",
+			body:           `{"version":4}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "get state",
+			method:         "GET",
+			target:         "/tfstates/network",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"version":4}`,
+		},
+		{
+			name:           "unlock",
+			method:         "UNLOCK",
+			target:         "/tfstates/network",
+			body:           httpInfo,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "delete state",
+			method:         "DELETE",
+			target:         "/tfstates/network",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "get deleted state",
+			method:         "GET",
+			target:         "/tfstates/network",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "Not found",
+		},
+	}
+
+	_, _, err := s.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/network", Attributes: map[string]string{"Info": dynamoDBInfo}}, store.NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, server.URL+c.target, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+
+			if resp.StatusCode != c.expectedStatus || string(body) != c.expectedBody {
+				t.Errorf("Expected %d %s, got %d %s", c.expectedStatus, c.expectedBody, resp.StatusCode, body)
+			}
+			if token := resp.Header.Get(FencingTokenHeader); token != c.expectedToken {
+				t.Errorf("Expected fencing token %q, got %q", c.expectedToken, token)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrIdempotentParameterMismatch = fmt.Errorf("client request token already used with different parameters")
+
+// tokenCache remembers the ClientRequestToken of successful transactions so
+// that a retried request is acknowledged without being applied twice.
+type tokenCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	tokens map[string]cachedToken
+	// inflight holds a channel per token being applied, closed once done
+	inflight map[string]chan struct{}
+}
+
+type cachedToken struct {
+	digest  [sha256.Size]byte
+	expires time.Time
+}
+
+func newTokenCache(ttl time.Duration) *tokenCache {
+	return &tokenCache{
+		ttl:      ttl,
+		tokens:   make(map[string]cachedToken),
+		inflight: make(map[string]chan struct{}),
+	}
+}
+
+// do runs apply unless token was already used successfully for the same
+// request body, in which case it reports the request as replayed. Requests
+// using the same token are serialized so that concurrent retries apply only
+// once, requests using other tokens run concurrently.
+func (c *tokenCache) do(token string, body []byte, apply func() error) (bool, error) {
+
+	digest := sha256.Sum256(body)
+	c.mu.Lock()
+	for {
+		now := time.Now()
+		for t, cached := range c.tokens {
+			if now.After(cached.expires) {
+				delete(c.tokens, t)
+			}
+		}
+
+		if cached, ok := c.tokens[token]; ok {
+			c.mu.Unlock()
+			if cached.digest != digest {
+				return false, ErrIdempotentParameterMismatch
+			}
+			return true, nil
+		}
+
+		done, ok := c.inflight[token]
+		if !ok {
+			break
+		}
+		c.mu.Unlock()
+		<-done
+		c.mu.Lock()
+	}
+	done := make(chan struct{})
+	c.inflight[token] = done
+	c.mu.Unlock()
+
+	err := apply()
+
+	c.mu.Lock()
+	if err == nil {
+		c.tokens[token] = cachedToken{digest: digest, expires: time.Now().Add(c.ttl)}
+	}
+	delete(c.inflight, token)
+	close(done)
+	c.mu.Unlock()
+
+	return false, err
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenCache(t *testing.T) {
+
+	tokens := newTokenCache(time.Minute)
+
+	// A slow transaction does not hold up those using other tokens
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go tokens.do("slow", []byte("a"), func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	replayed, err := tokens.do("other", []byte("b"), func() error { return nil })
+	if replayed || err != nil {
+		t.Errorf("Expected other token applied, got %v %v", replayed, err)
+	}
+
+	// Concurrent retries of the same token apply once
+	var applied int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens.do("retried", []byte("c"), func() error {
+				atomic.AddInt32(&applied, 1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if applied != 1 {
+		t.Errorf("Expected 1 application, got %d", applied)
+	}
+
+	close(release)
+
+	_, err = tokens.do("retried", []byte("d"), func() error { return nil })
+	if !errors.Is(err, ErrIdempotentParameterMismatch) {
+		t.Errorf("Expected error %v, got %v", ErrIdempotentParameterMismatch, err)
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// callerIdentity returns the access key ID a request was signed with, read
+// from its SigV4 Authorization header or presigned query string, or an empty
+// string for unsigned requests. The signature itself is not verified since
+// the locker does not know the secret keys, so the identity is only as
+// trustworthy as the network clients come from.
+func callerIdentity(r *http.Request) string {
+
+	credential := r.URL.Query().Get("X-Amz-Credential")
+
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 ") {
+		for _, field := range strings.Split(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 "), ",") {
+			field = strings.TrimSpace(field)
+			if strings.HasPrefix(field, "Credential=") {
+				credential = strings.TrimPrefix(field, "Credential=")
+			}
+		}
+	}
+
+	// The credential scope is <access key>/<date>/<region>/<service>/aws4_request
+	accessKey, _, _ := strings.Cut(credential, "/")
+
+	return accessKey
+}
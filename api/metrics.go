@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+// labelEscaper escapes a label value of the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// entryGauges caches the held locks and state digests counted in every
+// table, so that scrapes do not scan the store more than once per ttl.
+type entryGauges struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	expires time.Time
+	locks   []byte
+	digests []byte
+}
+
+func newEntryGauges(ttl time.Duration) *entryGauges {
+	return &entryGauges{ttl: ttl}
+}
+
+// get returns the gauge lines of the held locks and state digests, counting
+// them again once they are older than ttl. Concurrent scrapes wait for the
+// same count.
+func (g *entryGauges) get(ctx context.Context, s store.Store, opts Options) ([]byte, []byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(g.expires) {
+		return g.locks, g.digests, nil
+	}
+
+	tables, err := s.Tables(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing tables: %w", err)
+	}
+
+	var locks, digests bytes.Buffer
+	for _, table := range tables {
+		if table == opts.HTTPStateTable {
+			continue
+		}
+
+		entries, err := s.Scan(ctx, table, "", 0)
+		if err != nil && !errors.Is(err, store.ErrTableNotFound) {
+			return nil, nil, fmt.Errorf("scanning table: %w", err)
+		}
+
+		counts := map[store.EntryKind]int{}
+		for _, entry := range entries {
+			counts[entry.Kind]++
+		}
+
+		label := labelEscaper.Replace(table)
+		fmt.Fprintf(&locks, "terraform_state_locker_held_locks{table=\"%s\"} %d\n", label, counts[store.KindLock])
+		fmt.Fprintf(&digests, "terraform_state_locker_state_digests{table=\"%s\"} %d\n", label, counts[store.KindDigest])
+	}
+
+	g.locks, g.digests, g.expires = locks.Bytes(), digests.Bytes(), now.Add(g.ttl)
+
+	return g.locks, g.digests, nil
+}
+
+// handleMetrics exposes gauges in the Prometheus text format. State digests
+// are counted apart from held locks, and the states of the http backend are
+// not counted at all. A replica also exports its replication lag.
+func handleMetrics(w http.ResponseWriter, r *http.Request, s store.Store, opts Options, gauges *entryGauges) {
+
+	locks, digests, err := gauges.get(r.Context(), s, opts)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Counting entries: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "# HELP terraform_state_locker_held_locks Number of locks currently held.\n")
+	fmt.Fprintf(w, "# TYPE terraform_state_locker_held_locks gauge\n")
+	w.Write(locks)
+	fmt.Fprintf(w, "# HELP terraform_state_locker_state_digests Number of state digests stored by the S3 backend.\n")
+	fmt.Fprintf(w, "# TYPE terraform_state_locker_state_digests gauge\n")
+	w.Write(digests)
+
+	if opts.Replication != nil && opts.Replication.IsReplica() {
+		records, lag := opts.Replication.Lag()
+		fmt.Fprintf(w, "# HELP terraform_state_locker_replication_lag_records Number of records the replica is known to be behind its primary.\n")
+		fmt.Fprintf(w, "# TYPE terraform_state_locker_replication_lag_records gauge\n")
+		fmt.Fprintf(w, "terraform_state_locker_replication_lag_records %d\n", records)
+		fmt.Fprintf(w, "# HELP terraform_state_locker_replication_lag_seconds Time since the replica was last in sync with its primary.\n")
+		fmt.Fprintf(w, "# TYPE terraform_state_locker_replication_lag_seconds gauge\n")
+		fmt.Fprintf(w, "terraform_state_locker_replication_lag_seconds %g\n", lag.Seconds())
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+func TestMetrics(t *testing.T) {
+
+	ctx := context.Background()
+	s := store.NewInMemoryStore()
+	server := httptest.NewServer(newRouter(s, Options{HTTPStateTable: "terraform-http-state", AdminToken: "secret"}))
+	defer server.Close()
+
+	table := "locks \"eu\"\\prod\n"
+	s.Put(ctx, table, store.Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}, store.NotExists)
+
+	cases := []struct {
+		name           string
+		bearer         string
+		expectedStatus int
+		expectedLine   string
+	}{
+		{
+			name:           "unauthorized",
+			bearer:         "other",
+			expectedStatus: http.StatusUnauthorized,
+			expectedLine:   "Unauthorized",
+		},
+		{
+			name:           "escaped label",
+			bearer:         "secret",
+			expectedStatus: http.StatusOK,
+			expectedLine:   `terraform_state_locker_held_locks{table="locks \"eu\"\\prod\n"} 2`,
+		},
+		{
+			name:           "cached count",
+			bearer:         "secret",
+			expectedStatus: http.StatusOK,
+			expectedLine:   `terraform_state_locker_held_locks{table="locks \"eu\"\\prod\n"} 2`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", server.URL+"/metrics", nil)
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+c.bearer)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+
+			if resp.StatusCode != c.expectedStatus || !strings.Contains(string(body), c.expectedLine) {
+				t.Errorf("Expected %d %s, got %d %s", c.expectedStatus, c.expectedLine, resp.StatusCode, body)
+			}
+		})
+
+		// Locks acquired after a scrape are counted by the next count only
+		s.Put(ctx, table, store.Item{ID: "tfstates/" + c.name}, nil)
+	}
+}
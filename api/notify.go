@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+	"github.com/pablo-ruth/terraform-state-locker/webhook"
+)
+
+// notify sends a lifecycle event of a lock to the webhooks, if any. info is
+// the Info attribute of the lock.
+func notify(webhooks *webhook.Dispatcher, eventType webhook.EventType, table, lockID, identity, info string) {
+
+	if webhooks == nil || store.Kind(lockID) != store.KindLock {
+		return
+	}
+
+	event := webhook.Event{
+		Type:     eventType,
+		Table:    table,
+		LockID:   lockID,
+		Identity: identity,
+		Time:     time.Now().UTC(),
+	}
+	if lockInfo, err := ParseLockInfo(info); err == nil {
+		event.Info, _ = json.Marshal(lockInfo)
+	}
+
+	webhooks.Notify(event)
+}
+
+// watchExpiries starts the sweeps sending the expiry events of the event
+// stream and of the webhooks, each with its own threshold.
+func watchExpiries(s *store.Publisher, opts Options) {
+
+	if opts.EventsHeldAfter > 0 {
+		go watchHeldLocks(s, opts, opts.EventsHeldAfter, func(table string, entry store.Entry) {
+			s.Publish(store.Change{Type: store.ChangeExpiry, Table: table, ID: entry.ID, Attributes: entry.Attributes})
+		})
+	}
+
+	if opts.Webhooks != nil && opts.Webhooks.HeldAfter() > 0 {
+		go watchHeldLocks(s, opts, opts.Webhooks.HeldAfter(), func(table string, entry store.Entry) {
+			notify(opts.Webhooks, webhook.Expiry, table, entry.ID, entry.Owner, entry.Attributes["Info"])
+		})
+	}
+}
+
+// watchHeldLocks calls expired once for every lock held for longer than
+// heldAfter, as told by the Created time of its lock info.
+func watchHeldLocks(s store.Store, opts Options, heldAfter time.Duration, expired func(table string, entry store.Entry)) {
+
+	notified := map[string]bool{}
+	for range time.Tick(time.Minute) {
+		notified = checkHeldLocks(s, opts, heldAfter, expired, notified, time.Now())
+	}
+}
+
+// checkHeldLocks calls expired for the locks held for longer than heldAfter
+// at now, skipping the locks in notified, and returns the locks notified so
+// far that are still held.
+func checkHeldLocks(s store.Store, opts Options, heldAfter time.Duration, expired func(table string, entry store.Entry), notified map[string]bool, now time.Time) map[string]bool {
+
+	ctx := context.Background()
+	tables, err := s.Tables(ctx)
+	if err != nil {
+		log.Printf("Listing tables: %v", err)
+		return notified
+	}
+
+	held := map[string]bool{}
+	for _, table := range tables {
+		if table == opts.HTTPStateTable {
+			continue
+		}
+
+		entries, err := s.Scan(ctx, table, "", 0)
+		if err != nil {
+			log.Printf("Scanning table: %v", err)
+			continue
+		}
+
+		for _, entry := range entries {
+			lockInfo, err := ParseLockInfo(entry.Attributes["Info"])
+			if entry.Kind != store.KindLock || err != nil || lockInfo.Created.IsZero() {
+				continue
+			}
+
+			// A lock acquired again is notified again
+			key := table + "\x00" + entry.ID + "\x00" + lockInfo.ID
+			if now.Sub(lockInfo.Created) < heldAfter {
+				continue
+			}
+
+			held[key] = true
+			if !notified[key] {
+				expired(table, entry)
+			}
+		}
+	}
+
+	return held
+}
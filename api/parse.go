@@ -3,6 +3,8 @@ package api
 import (
 	"encoding/json"
 	"io"
+	"strings"
+	"time"
 )
 
 type Item struct {
@@ -10,25 +12,286 @@ type Item struct {
 }
 
 type PutItemRequest struct {
-	Item                map[string]Item `json:"Item"`
-	TableName           string          `json:"TableName"`
-	ConditionExpression string          `json:"ConditionExpression"`
+	Item                                map[string]Item `json:"Item"`
+	TableName                           string          `json:"TableName"`
+	ConditionExpression                 string          `json:"ConditionExpression"`
+	ReturnValues                        string          `json:"ReturnValues"`
+	ReturnValuesOnConditionCheckFailure string          `json:"ReturnValuesOnConditionCheckFailure"`
 }
 
 type GetItemRequest struct {
-	Key       map[string]Item `json:"Key"`
-	TableName string          `json:"TableName"`
+	Key                      map[string]Item   `json:"Key"`
+	TableName                string            `json:"TableName"`
+	ProjectionExpression     string            `json:"ProjectionExpression"`
+	ExpressionAttributeNames map[string]string `json:"ExpressionAttributeNames"`
+	ConsistentRead           bool              `json:"ConsistentRead"`
 }
 
 type DeleteItemRequest struct {
-	Key       map[string]Item `json:"Key"`
-	TableName string          `json:"TableName"`
+	Key                                 map[string]Item   `json:"Key"`
+	TableName                           string            `json:"TableName"`
+	ConditionExpression                 string            `json:"ConditionExpression"`
+	ExpressionAttributeNames            map[string]string `json:"ExpressionAttributeNames"`
+	ExpressionAttributeValues           map[string]Item   `json:"ExpressionAttributeValues"`
+	ReturnValues                        string            `json:"ReturnValues"`
+	ReturnValuesOnConditionCheckFailure string            `json:"ReturnValuesOnConditionCheckFailure"`
+}
+
+type UpdateItemRequest struct {
+	Key                                 map[string]Item   `json:"Key"`
+	TableName                           string            `json:"TableName"`
+	UpdateExpression                    string            `json:"UpdateExpression"`
+	ConditionExpression                 string            `json:"ConditionExpression"`
+	ExpressionAttributeNames            map[string]string `json:"ExpressionAttributeNames"`
+	ExpressionAttributeValues           map[string]Item   `json:"ExpressionAttributeValues"`
+	ReturnValues                        string            `json:"ReturnValues"`
+	ReturnValuesOnConditionCheckFailure string            `json:"ReturnValuesOnConditionCheckFailure"`
 }
 
 type GetItemResponse struct {
 	Item map[string]Item `json:"Item"`
 }
 
+// WriteItemResponse is the response of PutItem, UpdateItem and DeleteItem,
+// Attributes holds the previous item when ReturnValues is ALL_OLD, or the
+// item written when it is ALL_NEW.
+type WriteItemResponse struct {
+	Attributes map[string]Item `json:"Attributes,omitempty"`
+}
+
+type ScanRequest struct {
+	TableName                 string            `json:"TableName"`
+	FilterExpression          string            `json:"FilterExpression"`
+	ExpressionAttributeNames  map[string]string `json:"ExpressionAttributeNames"`
+	ExpressionAttributeValues map[string]Item   `json:"ExpressionAttributeValues"`
+	Limit                     int               `json:"Limit"`
+	ExclusiveStartKey         map[string]Item   `json:"ExclusiveStartKey"`
+	Select                    string            `json:"Select"`
+	ProjectionExpression      string            `json:"ProjectionExpression"`
+}
+
+type QueryRequest struct {
+	TableName                 string            `json:"TableName"`
+	KeyConditionExpression    string            `json:"KeyConditionExpression"`
+	FilterExpression          string            `json:"FilterExpression"`
+	ExpressionAttributeNames  map[string]string `json:"ExpressionAttributeNames"`
+	ExpressionAttributeValues map[string]Item   `json:"ExpressionAttributeValues"`
+	Limit                     int               `json:"Limit"`
+	ExclusiveStartKey         map[string]Item   `json:"ExclusiveStartKey"`
+	Select                    string            `json:"Select"`
+	ProjectionExpression      string            `json:"ProjectionExpression"`
+}
+
+// ScanResponse is the response of both Scan and Query.
+type ScanResponse struct {
+	Items            []map[string]Item `json:"Items,omitempty"`
+	Count            int               `json:"Count"`
+	ScannedCount     int               `json:"ScannedCount"`
+	LastEvaluatedKey map[string]Item   `json:"LastEvaluatedKey,omitempty"`
+}
+
+// KeysAndAttributes lists the keys to read from one table in a BatchGetItem,
+// it is also used to report unprocessed keys.
+type KeysAndAttributes struct {
+	Keys                     []map[string]Item `json:"Keys"`
+	ProjectionExpression     string            `json:"ProjectionExpression,omitempty"`
+	ExpressionAttributeNames map[string]string `json:"ExpressionAttributeNames,omitempty"`
+	ConsistentRead           bool              `json:"ConsistentRead,omitempty"`
+}
+
+type BatchGetItemRequest struct {
+	RequestItems map[string]KeysAndAttributes `json:"RequestItems"`
+}
+
+type BatchGetItemResponse struct {
+	Responses       map[string][]map[string]Item `json:"Responses"`
+	UnprocessedKeys map[string]KeysAndAttributes `json:"UnprocessedKeys"`
+}
+
+// WriteRequest is a single put or delete of a BatchWriteItem, exactly one of
+// PutRequest and DeleteRequest must be set.
+type WriteRequest struct {
+	PutRequest    *PutRequest    `json:"PutRequest,omitempty"`
+	DeleteRequest *DeleteRequest `json:"DeleteRequest,omitempty"`
+}
+
+type PutRequest struct {
+	Item map[string]Item `json:"Item"`
+}
+
+type DeleteRequest struct {
+	Key map[string]Item `json:"Key"`
+}
+
+type BatchWriteItemRequest struct {
+	RequestItems map[string][]WriteRequest `json:"RequestItems"`
+}
+
+type BatchWriteItemResponse struct {
+	UnprocessedItems map[string][]WriteRequest `json:"UnprocessedItems"`
+}
+
+// TransactWriteOperation is a Put, Update, Delete or ConditionCheck of a
+// TransactWriteItems. Put uses Item, the others use Key.
+type TransactWriteOperation struct {
+	TableName                           string            `json:"TableName"`
+	Item                                map[string]Item   `json:"Item,omitempty"`
+	Key                                 map[string]Item   `json:"Key,omitempty"`
+	UpdateExpression                    string            `json:"UpdateExpression,omitempty"`
+	ConditionExpression                 string            `json:"ConditionExpression,omitempty"`
+	ExpressionAttributeNames            map[string]string `json:"ExpressionAttributeNames,omitempty"`
+	ExpressionAttributeValues           map[string]Item   `json:"ExpressionAttributeValues,omitempty"`
+	ReturnValuesOnConditionCheckFailure string            `json:"ReturnValuesOnConditionCheckFailure,omitempty"`
+}
+
+// TransactWriteItem holds exactly one operation of a TransactWriteItems.
+type TransactWriteItem struct {
+	Put            *TransactWriteOperation `json:"Put,omitempty"`
+	Update         *TransactWriteOperation `json:"Update,omitempty"`
+	Delete         *TransactWriteOperation `json:"Delete,omitempty"`
+	ConditionCheck *TransactWriteOperation `json:"ConditionCheck,omitempty"`
+}
+
+type TransactWriteItemsRequest struct {
+	TransactItems      []TransactWriteItem `json:"TransactItems"`
+	ClientRequestToken string              `json:"ClientRequestToken"`
+}
+
+type TransactGetItem struct {
+	Get *GetItemRequest `json:"Get"`
+}
+
+type TransactGetItemsRequest struct {
+	TransactItems []TransactGetItem `json:"TransactItems"`
+}
+
+type ItemResponse struct {
+	Item map[string]Item `json:"Item,omitempty"`
+}
+
+type TransactGetItemsResponse struct {
+	Responses []ItemResponse `json:"Responses"`
+}
+
+// ErrorResponse is the body of DynamoDB errors that clients need to inspect.
+type ErrorResponse struct {
+	Type                string               `json:"__type"`
+	Message             string               `json:"message"`
+	Item                map[string]Item      `json:"Item,omitempty"`
+	CancellationReasons []CancellationReason `json:"CancellationReasons,omitempty"`
+}
+
+type CancellationReason struct {
+	Code    string          `json:"Code"`
+	Message string          `json:"Message,omitempty"`
+	Item    map[string]Item `json:"Item,omitempty"`
+}
+
+// LockInfo is the lock information Terraform stores in the Info attribute of
+// a lock.
+type ListStreamsRequest struct {
+	TableName               string `json:"TableName"`
+	Limit                   int    `json:"Limit"`
+	ExclusiveStartStreamArn string `json:"ExclusiveStartStreamArn"`
+}
+
+type Stream struct {
+	StreamArn   string `json:"StreamArn"`
+	StreamLabel string `json:"StreamLabel"`
+	TableName   string `json:"TableName"`
+}
+
+type ListStreamsResponse struct {
+	Streams                []Stream `json:"Streams"`
+	LastEvaluatedStreamArn string   `json:"LastEvaluatedStreamArn,omitempty"`
+}
+
+type DescribeStreamRequest struct {
+	StreamArn             string `json:"StreamArn"`
+	Limit                 int    `json:"Limit"`
+	ExclusiveStartShardId string `json:"ExclusiveStartShardId"`
+}
+
+type KeySchemaElement struct {
+	AttributeName string `json:"AttributeName"`
+	KeyType       string `json:"KeyType"`
+}
+
+type SequenceNumberRange struct {
+	StartingSequenceNumber string `json:"StartingSequenceNumber"`
+}
+
+type Shard struct {
+	ShardId             string              `json:"ShardId"`
+	SequenceNumberRange SequenceNumberRange `json:"SequenceNumberRange"`
+}
+
+type StreamDescription struct {
+	StreamArn               string             `json:"StreamArn"`
+	StreamLabel             string             `json:"StreamLabel"`
+	StreamStatus            string             `json:"StreamStatus"`
+	StreamViewType          string             `json:"StreamViewType"`
+	CreationRequestDateTime float64            `json:"CreationRequestDateTime"`
+	TableName               string             `json:"TableName"`
+	KeySchema               []KeySchemaElement `json:"KeySchema"`
+	Shards                  []Shard            `json:"Shards"`
+	LastEvaluatedShardId    string             `json:"LastEvaluatedShardId,omitempty"`
+}
+
+type DescribeStreamResponse struct {
+	StreamDescription StreamDescription `json:"StreamDescription"`
+}
+
+type GetShardIteratorRequest struct {
+	StreamArn         string `json:"StreamArn"`
+	ShardId           string `json:"ShardId"`
+	ShardIteratorType string `json:"ShardIteratorType"`
+	SequenceNumber    string `json:"SequenceNumber"`
+}
+
+type GetShardIteratorResponse struct {
+	ShardIterator string `json:"ShardIterator"`
+}
+
+type GetRecordsRequest struct {
+	ShardIterator string `json:"ShardIterator"`
+	Limit         int    `json:"Limit"`
+}
+
+type StreamRecord struct {
+	ApproximateCreationDateTime float64         `json:"ApproximateCreationDateTime"`
+	Keys                        map[string]Item `json:"Keys"`
+	NewImage                    map[string]Item `json:"NewImage,omitempty"`
+	OldImage                    map[string]Item `json:"OldImage,omitempty"`
+	SequenceNumber              string          `json:"SequenceNumber"`
+	SizeBytes                   int             `json:"SizeBytes"`
+	StreamViewType              string          `json:"StreamViewType"`
+}
+
+type Record struct {
+	EventID      string       `json:"eventID"`
+	EventName    string       `json:"eventName"`
+	EventVersion string       `json:"eventVersion"`
+	EventSource  string       `json:"eventSource"`
+	AwsRegion    string       `json:"awsRegion"`
+	Dynamodb     StreamRecord `json:"dynamodb"`
+}
+
+type GetRecordsResponse struct {
+	Records           []Record `json:"Records"`
+	NextShardIterator string   `json:"NextShardIterator,omitempty"`
+}
+
+type LockInfo struct {
+	ID        string    `json:"ID"`
+	Operation string    `json:"Operation"`
+	Info      string    `json:"Info"`
+	Who       string    `json:"Who"`
+	Version   string    `json:"Version"`
+	Created   time.Time `json:"Created"`
+	Path      string    `json:"Path"`
+}
+
 func ParsePutItemRequest(body io.Reader) (PutItemRequest, error) {
 
 	var putItemRequest PutItemRequest
@@ -45,6 +308,14 @@ func ParseGetItemRequest(body io.Reader) (GetItemRequest, error) {
 	return getItemRequest, err
 }
 
+func ParseUpdateItemRequest(body io.Reader) (UpdateItemRequest, error) {
+
+	var updateItemRequest UpdateItemRequest
+	err := json.NewDecoder(body).Decode(&updateItemRequest)
+
+	return updateItemRequest, err
+}
+
 func ParseDeleteItemRequest(body io.Reader) (DeleteItemRequest, error) {
 
 	var deleteItemRequest DeleteItemRequest
@@ -52,3 +323,91 @@ func ParseDeleteItemRequest(body io.Reader) (DeleteItemRequest, error) {
 
 	return deleteItemRequest, err
 }
+
+func ParseScanRequest(body io.Reader) (ScanRequest, error) {
+
+	var scanRequest ScanRequest
+	err := json.NewDecoder(body).Decode(&scanRequest)
+
+	return scanRequest, err
+}
+
+func ParseQueryRequest(body io.Reader) (QueryRequest, error) {
+
+	var queryRequest QueryRequest
+	err := json.NewDecoder(body).Decode(&queryRequest)
+
+	return queryRequest, err
+}
+
+func ParseBatchGetItemRequest(body io.Reader) (BatchGetItemRequest, error) {
+
+	var batchGetItemRequest BatchGetItemRequest
+	err := json.NewDecoder(body).Decode(&batchGetItemRequest)
+
+	return batchGetItemRequest, err
+}
+
+func ParseBatchWriteItemRequest(body io.Reader) (BatchWriteItemRequest, error) {
+
+	var batchWriteItemRequest BatchWriteItemRequest
+	err := json.NewDecoder(body).Decode(&batchWriteItemRequest)
+
+	return batchWriteItemRequest, err
+}
+
+func ParseTransactWriteItemsRequest(body io.Reader) (TransactWriteItemsRequest, error) {
+
+	var transactWriteItemsRequest TransactWriteItemsRequest
+	err := json.NewDecoder(body).Decode(&transactWriteItemsRequest)
+
+	return transactWriteItemsRequest, err
+}
+
+func ParseTransactGetItemsRequest(body io.Reader) (TransactGetItemsRequest, error) {
+
+	var transactGetItemsRequest TransactGetItemsRequest
+	err := json.NewDecoder(body).Decode(&transactGetItemsRequest)
+
+	return transactGetItemsRequest, err
+}
+
+func ParseListStreamsRequest(body io.Reader) (ListStreamsRequest, error) {
+
+	var listStreamsRequest ListStreamsRequest
+	err := json.NewDecoder(body).Decode(&listStreamsRequest)
+
+	return listStreamsRequest, err
+}
+
+func ParseDescribeStreamRequest(body io.Reader) (DescribeStreamRequest, error) {
+
+	var describeStreamRequest DescribeStreamRequest
+	err := json.NewDecoder(body).Decode(&describeStreamRequest)
+
+	return describeStreamRequest, err
+}
+
+func ParseGetShardIteratorRequest(body io.Reader) (GetShardIteratorRequest, error) {
+
+	var getShardIteratorRequest GetShardIteratorRequest
+	err := json.NewDecoder(body).Decode(&getShardIteratorRequest)
+
+	return getShardIteratorRequest, err
+}
+
+func ParseGetRecordsRequest(body io.Reader) (GetRecordsRequest, error) {
+
+	var getRecordsRequest GetRecordsRequest
+	err := json.NewDecoder(body).Decode(&getRecordsRequest)
+
+	return getRecordsRequest, err
+}
+
+func ParseLockInfo(info string) (LockInfo, error) {
+
+	var lockInfo LockInfo
+	err := json.NewDecoder(strings.NewReader(info)).Decode(&lockInfo)
+
+	return lockInfo, err
+}
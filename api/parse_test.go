@@ -18,7 +18,8 @@ func TestParsePutItemRequest(t *testing.T) {
 				S: "tfstates/dynamodbtest",
 			},
 		},
-		TableName: "terraform-lock-table",
+		TableName:           "terraform-lock-table",
+		ConditionExpression: "attribute_not_exists(LockID)",
 	}
 
 	putItemRequest, err := ParsePutItemRequest(strings.NewReader(body))
@@ -26,7 +27,7 @@ func TestParsePutItemRequest(t *testing.T) {
 		t.Errorf("Error parsing PutItemRequest: %v", err)
 	}
 
-	if reflect.DeepEqual(expected, putItemRequest) {
+	if !reflect.DeepEqual(expected, putItemRequest) {
 		t.Errorf("Expected: %v\nGot: %v", expected, putItemRequest)
 	}
 }
@@ -40,7 +41,9 @@ func TestParseGetItemRequest(t *testing.T) {
 				S: "tfstates/dynamodbtest",
 			},
 		},
-		TableName: "terraform-lock-table",
+		TableName:            "terraform-lock-table",
+		ProjectionExpression: "LockID,Info",
+		ConsistentRead:       true,
 	}
 
 	getItemRequest, err := ParseGetItemRequest(strings.NewReader(body))
@@ -48,7 +51,42 @@ func TestParseGetItemRequest(t *testing.T) {
 		t.Errorf("Error parsing GetItemRequest: %v", err)
 	}
 
-	if reflect.DeepEqual(expected, getItemRequest) {
+	if !reflect.DeepEqual(expected, getItemRequest) {
 		t.Errorf("Expected: %v\nGot: %v", expected, getItemRequest)
 	}
 }
+
+func TestParseBatchWriteItemRequest(t *testing.T) {
+
+	body := `{"RequestItems":{"terraform-lock-table":[{"PutRequest":{"Item":{"LockID":{"S":"tfstates/network"},"Info":{"S":"Test"}}}},{"DeleteRequest":{"Key":{"LockID":{"S":"tfstates/dns"}}}}]}}`
+	expected := BatchWriteItemRequest{
+		RequestItems: map[string][]WriteRequest{
+			"terraform-lock-table": {
+				{
+					PutRequest: &PutRequest{
+						Item: map[string]Item{
+							"LockID": {S: "tfstates/network"},
+							"Info":   {S: "Test"},
+						},
+					},
+				},
+				{
+					DeleteRequest: &DeleteRequest{
+						Key: map[string]Item{
+							"LockID": {S: "tfstates/dns"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	batchWriteItemRequest, err := ParseBatchWriteItemRequest(strings.NewReader(body))
+	if err != nil {
+		t.Errorf("Error parsing BatchWriteItemRequest: %v", err)
+	}
+
+	if !reflect.DeepEqual(expected, batchWriteItemRequest) {
+		t.Errorf("Expected: %v\nGot: %v", expected, batchWriteItemRequest)
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+// waitQueue orders the callers waiting for a lock so that they acquire it in
+// the order they first failed to, instead of whoever happens to retry first.
+// A waiter loses its place when it does not retry within ttl.
+type waitQueue struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	queues map[store.Key][]Waiter
+}
+
+// Waiter is a caller waiting for a lock, identified by the ID of its lock
+// info which Terraform keeps while retrying.
+type Waiter struct {
+	ID       string    `json:"ID"`
+	Who      string    `json:"Who,omitempty"`
+	Since    time.Time `json:"Since"`
+	LastSeen time.Time `json:"LastSeen"`
+}
+
+// WaitQueue lists the waiters of a lock, the head first.
+type WaitQueue struct {
+	LockID  string   `json:"LockID"`
+	Waiters []Waiter `json:"Waiters"`
+}
+
+func newWaitQueue(ttl time.Duration) *waitQueue {
+	return &waitQueue{
+		ttl:    ttl,
+		queues: make(map[store.Key][]Waiter),
+	}
+}
+
+// admit reports whether waiter may try to acquire the lock, that is when
+// nobody else waits ahead of it.
+func (q *waitQueue) admit(key store.Key, waiter Waiter) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queue := q.prune(key, time.Now())
+	return len(queue) == 0 || queue[0].ID == waiter.ID
+}
+
+// wait queues waiter for the lock, or refreshes its place if it already
+// waits.
+func (q *waitQueue) wait(key store.Key, waiter Waiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	queue := q.prune(key, now)
+	for i := range queue {
+		if queue[i].ID == waiter.ID {
+			queue[i].LastSeen = now
+			return
+		}
+	}
+
+	waiter.Since, waiter.LastSeen = now, now
+	q.queues[key] = append(queue, waiter)
+}
+
+// done removes waiter from the queue once it acquired the lock.
+func (q *waitQueue) done(key store.Key, waiter Waiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queue := q.queues[key]
+	for i := range queue {
+		if queue[i].ID == waiter.ID {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+
+	if len(queue) == 0 {
+		delete(q.queues, key)
+		return
+	}
+	q.queues[key] = queue
+}
+
+// list returns the queues of table sorted by LockID.
+func (q *waitQueue) list(table string) []WaitQueue {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	result := []WaitQueue{}
+	for key := range q.queues {
+		if key.Table != table {
+			continue
+		}
+
+		if queue := q.prune(key, now); len(queue) > 0 {
+			result = append(result, WaitQueue{LockID: key.ID, Waiters: append([]Waiter(nil), queue...)})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LockID < result[j].LockID
+	})
+
+	return result
+}
+
+// prune drops the waiters that stopped retrying and returns the queue. The
+// caller must hold q.mu.
+func (q *waitQueue) prune(key store.Key, now time.Time) []Waiter {
+
+	queue := q.queues[key][:0]
+	for _, waiter := range q.queues[key] {
+		if now.Sub(waiter.LastSeen) <= q.ttl {
+			queue = append(queue, waiter)
+		}
+	}
+
+	if len(queue) == 0 {
+		delete(q.queues, key)
+		return nil
+	}
+	q.queues[key] = queue
+
+	return queue
+}
+
+// requestWaiter identifies the caller of a lock acquisition by the ID of its
+// lock info, falling back to its identity.
+func requestWaiter(r *http.Request, attributes map[string]string) Waiter {
+
+	lockInfo, err := ParseLockInfo(attributes["Info"])
+	if err == nil && lockInfo.ID != "" {
+		return Waiter{ID: lockInfo.ID, Who: lockInfo.Who}
+	}
+
+	return Waiter{ID: callerIdentity(r)}
+}
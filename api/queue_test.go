@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+func TestWaitQueue(t *testing.T) {
+
+	q := newWaitQueue(50 * time.Millisecond)
+	key := store.Key{Table: "terraform-lock-table", ID: "tfstates/network"}
+	first, second := Waiter{ID: "first"}, Waiter{ID: "second"}
+
+	q.wait(key, first)
+	q.wait(key, second)
+
+	cases := []struct {
+		name     string
+		waiter   Waiter
+		expected bool
+	}{
+		{
+			name:     "head of the queue",
+			waiter:   first,
+			expected: true,
+		},
+		{
+			name:     "behind the head",
+			waiter:   second,
+			expected: false,
+		},
+		{
+			name:     "not queued",
+			waiter:   Waiter{ID: "third"},
+			expected: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if admitted := q.admit(key, c.waiter); admitted != c.expected {
+				t.Errorf("Expected %v, got %v", c.expected, admitted)
+			}
+		})
+	}
+
+	q.done(key, first)
+	if !q.admit(key, second) {
+		t.Errorf("Expected second to be admitted once first is done")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if queues := q.list(key.Table); len(queues) != 0 {
+		t.Errorf("Expected expired waiters to be dropped, got %v", queues)
+	}
+	if !q.admit(key, Waiter{ID: "third"}) {
+		t.Errorf("Expected third to be admitted once the queue expired")
+	}
+}
+
+func TestAcquisitionQueue(t *testing.T) {
+
+	ctx := context.Background()
+	s := store.NewInMemoryStore()
+	server := httptest.NewServer(newRouter(s, Options{
+		LockQueueTTL:   time.Minute,
+		HTTPLockTable:  "terraform-lock-table",
+		HTTPStateTable: "terraform-http-state",
+	}))
+	defer server.Close()
+
+	holderInfo := `{"ID":"bc4abeab-0f07-e6b0-8b6d-a68460074a8e","Operation":"OperationTypePlan"}`
+	httpInfo := `{"ID":"2f5b4b3e-93a8-4d8a-9d1e-4b8e3c1a2b3c","Operation":"OperationTypeApply"}`
+	transact := `{"TransactItems":[{"Put":{"TableName":"terraform-lock-table","Item":{"LockID":{"S":"tfstates/network"},"Info":{"S":"{\"ID\":\"9b2c1e0a-5d4f-4e3b-8a7c-6d5e4f3a2b1c\"}"}},"ConditionExpression":"attribute_not_exists(LockID)"}}]}`
+	canceled := `{"__type":"com.amazonaws.dynamodb.v20120810#TransactionCanceledException","message":"Transaction cancelled, please refer cancellation reasons for specific reasons [ConditionalCheckFailed]","CancellationReasons":[{"Code":"ConditionalCheckFailed","Message":"The conditional request failed"}]}`
+
+	cases := []struct {
+		name           string
+		method         string
+		target         string
+		header         string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "lock a held lock",
+			method:         "LOCK",
+			target:         "/state/tfstates/network",
+			body:           httpInfo,
+			expectedStatus: http.StatusLocked,
+			expectedBody:   holderInfo,
+		},
+		{
+			name:           "acquire a held lock in a transaction",
+			method:         "POST",
+			target:         "/",
+			header:         "DynamoDB_20120810.TransactWriteItems",
+			body:           transact,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   canceled,
+		},
+		{
+			name:           "release",
+			method:         "POST",
+			target:         "/",
+			header:         "DynamoDB_20120810.DeleteItem",
+			body:           `{"TableName":"terraform-lock-table","Key":{"LockID":{"S":"tfstates/network"}}}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{}`,
+		},
+		{
+			name:           "acquire in a transaction behind a waiter",
+			method:         "POST",
+			target:         "/",
+			header:         "DynamoDB_20120810.TransactWriteItems",
+			body:           transact,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   canceled,
+		},
+		{
+			name:           "lock at the head of the queue",
+			method:         "LOCK",
+			target:         "/state/tfstates/network",
+			body:           httpInfo,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	_, _, err := s.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/network", Attributes: map[string]string{"Info": holderInfo}}, store.NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, server.URL+c.target, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			req.Header.Set("X-Amz-Target", c.header)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+
+			if resp.StatusCode != c.expectedStatus || string(body) != c.expectedBody {
+				t.Errorf("Expected %d %s, got %d %s", c.expectedStatus, c.expectedBody, resp.StatusCode, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+// replicationRouter serves the replication stream of the primary to its
+// replicas, every request must carry token as a bearer token.
+func replicationRouter(s *store.ReplicatedStore, token string) http.Handler {
+
+	r := chi.NewRouter()
+	r.Use(requireBearer(token))
+	r.Get("/stream", func(w http.ResponseWriter, r *http.Request) {
+		s.ServeReplication(w, r)
+	})
+
+	return r
+}
+
+// rejectOnReplica rejects a DynamoDB write when the store is a replica and
+// reports whether it did.
+func rejectOnReplica(w http.ResponseWriter, r *http.Request, s *store.ReplicatedStore) bool {
+
+	target := r.Header.Get("X-Amz-Target")
+	if !isWrite(target) || !s.IsReplica() {
+		return false
+	}
+
+	writeError(w, http.StatusBadRequest, ErrorResponse{Type: "ValidationException", Message: "This server is a read-only replica, write to the primary"})
+	log.Printf("Rejecting %s on a replica", target)
+
+	return true
+}
+
+// readOnlyMessage explains to the callers of the http backend and the admin
+// API that a write was sent to a replica.
+const readOnlyMessage = "This server is a read-only replica, write to the primary"
+
+// readOnlyOnReplica rejects the requests of a router other than GET and HEAD
+// while s is a replica, rather than failing them once they reach the store.
+func readOnlyOnReplica(s *store.ReplicatedStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && s.IsReplica() {
+				w.WriteHeader(http.StatusMisdirectedRequest)
+				w.Write([]byte(readOnlyMessage))
+				log.Printf("Rejecting %s %s on a replica", r.Method, r.URL.Path)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rejectReadOnly writes the response of a write the store refused because it
+// is a replica and reports whether err is that.
+func rejectReadOnly(w http.ResponseWriter, err error) bool {
+
+	if !errors.Is(err, store.ErrReadOnly) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusMisdirectedRequest)
+	w.Write([]byte(readOnlyMessage))
+
+	return true
+}
+
+// handlePromote promotes a replica to primary.
+func handlePromote(w http.ResponseWriter, r *http.Request, s *store.ReplicatedStore) {
+
+	if s == nil || !s.IsReplica() {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Not a replica"))
+		return
+	}
+
+	err := s.Promote()
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+func TestReplica(t *testing.T) {
+
+	ctx := context.Background()
+	primary := store.NewPrimary()
+	primaryServer := httptest.NewServer(newRouter(primary, Options{Replication: primary, ReplicationToken: "replication"}))
+	defer primaryServer.Close()
+
+	_, _, err := primary.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}, store.NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	replica := store.NewReplica(primaryServer.URL+"/replication/stream", "replication", primaryServer.Client())
+	defer replica.Close()
+	server := httptest.NewServer(newRouter(replica, Options{Replication: replica, AdminToken: "secret"}))
+	defer server.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for replica.LastSeq() != primary.LastSeq() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected seq %d, got %d", primary.LastSeq(), replica.LastSeq())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	put := `{"TableName":"terraform-lock-table","Item":{"LockID":{"S":"tfstates/dns"}},"ConditionExpression":"attribute_not_exists(LockID)"}`
+
+	cases := []struct {
+		name           string
+		method         string
+		url            string
+		target         string
+		header         string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "wrong replication token",
+			method:         "GET",
+			url:            primaryServer.URL,
+			target:         "/replication/stream",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   "Unauthorized",
+		},
+		{
+			name:           "write to a replica",
+			method:         "POST",
+			url:            server.URL,
+			target:         "/",
+			header:         "DynamoDB_20120810.PutItem",
+			body:           put,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"__type":"com.amazonaws.dynamodb.v20120810#ValidationException","message":"This server is a read-only replica, write to the primary"}`,
+		},
+		{
+			name:           "lock through the http backend of a replica",
+			method:         "LOCK",
+			url:            server.URL,
+			target:         "/state/tfstates/dns",
+			body:           `{"ID":"2f5b4b3e-93a8-4d8a-9d1e-4b8e3c1a2b3c"}`,
+			expectedStatus: http.StatusMisdirectedRequest,
+			expectedBody:   "This server is a read-only replica, write to the primary",
+		},
+		{
+			name:           "reset a digest on a replica",
+			method:         "PUT",
+			url:            server.URL,
+			target:         "/admin/tables/terraform-lock-table/digests/tfstates/dns",
+			body:           `{"Digest":"d41d8cd98f00b204e9800998ecf8427e"}`,
+			expectedStatus: http.StatusMisdirectedRequest,
+			expectedBody:   "This server is a read-only replica, write to the primary",
+		},
+		{
+			name:           "promote",
+			method:         "POST",
+			url:            server.URL,
+			target:         "/admin/replication/promote",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "promote again",
+			method:         "POST",
+			url:            server.URL,
+			target:         "/admin/replication/promote",
+			expectedStatus: http.StatusConflict,
+			expectedBody:   "Not a replica",
+		},
+		{
+			name:           "write to a promoted replica",
+			method:         "POST",
+			url:            server.URL,
+			target:         "/",
+			header:         "DynamoDB_20120810.PutItem",
+			body:           put,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, c.url+c.target, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			req.Header.Set("X-Amz-Target", c.header)
+			req.Header.Set("Authorization", "Bearer secret")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+
+			if resp.StatusCode != c.expectedStatus || string(body) != c.expectedBody {
+				t.Errorf("Expected %d %s, got %d %s", c.expectedStatus, c.expectedBody, resp.StatusCode, body)
+			}
+		})
+	}
+
+	entry, err := replica.Get(ctx, "terraform-lock-table", "tfstates/network")
+	if err != nil || entry.Attributes["Info"] != "Test" {
+		t.Errorf("Expected %v, got %v %v", "Test", entry, err)
+	}
+}
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pablo-ruth/terraform-state-locker/blob"
+)
+
+const maxListKeys = 1000
+
+// s3Router serves a minimal path-style S3 API, enough for the state objects
+// of Terraform's S3 backend: configure it with an endpoint ending in /s3 and
+// path-style addressing. Buckets exist implicitly.
+func s3Router(blobs blob.Store) http.Handler {
+
+	r := chi.NewRouter()
+	r.Get("/{bucket}", func(w http.ResponseWriter, r *http.Request) {
+		handleListObjects(w, r, blobs)
+	})
+	r.Head("/{bucket}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	r.Put("/{bucket}/*", func(w http.ResponseWriter, r *http.Request) {
+		handlePutObject(w, r, blobs)
+	})
+	r.Get("/{bucket}/*", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "*") == "" {
+			handleListObjects(w, r, blobs)
+			return
+		}
+		handleGetObject(w, r, blobs, true)
+	})
+	r.Head("/{bucket}/*", func(w http.ResponseWriter, r *http.Request) {
+		handleGetObject(w, r, blobs, false)
+	})
+	r.Delete("/{bucket}/*", func(w http.ResponseWriter, r *http.Request) {
+		handleDeleteObject(w, r, blobs)
+	})
+
+	return r
+}
+
+type S3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+	Key     string   `xml:"Key,omitempty"`
+}
+
+type ListBucketResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []S3Object     `xml:"Contents"`
+	CommonPrefixes        []CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type S3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func handlePutObject(w http.ResponseWriter, r *http.Request, blobs blob.Store) {
+
+	bucket, key := chi.URLParam(r, "bucket"), chi.URLParam(r, "*")
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, S3Error{Code: "IncompleteBody", Message: "Could not read the request body."})
+		log.Printf("Reading object: %v", err)
+		return
+	}
+
+	// Recent SDKs stream uploads with aws-chunked encoding to send checksums
+	// as trailers
+	if strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") || strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		data, err = decodeAWSChunked(data)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, S3Error{Code: "IncompleteBody", Message: "Invalid aws-chunked encoding."})
+			log.Printf("Decoding object: %v", err)
+			return
+		}
+	}
+
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		digest := md5.Sum(data)
+		if contentMD5 != base64.StdEncoding.EncodeToString(digest[:]) {
+			writeS3Error(w, http.StatusBadRequest, S3Error{Code: "BadDigest", Message: "The Content-MD5 you specified did not match what we received.", Key: key})
+			return
+		}
+	}
+
+	object, err := blobs.Put(bucket, key, data)
+	if err != nil {
+		if err == blob.ErrInvalidKey {
+			writeS3Error(w, http.StatusBadRequest, S3Error{Code: "InvalidArgument", Message: "Invalid object key.", Key: key})
+			return
+		}
+
+		writeS3Error(w, http.StatusInternalServerError, S3Error{Code: "InternalError", Message: "We encountered an internal error. Please try again."})
+		log.Printf("Storing object: %v", err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+object.ETag+`"`)
+	if object.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", object.VersionID)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleGetObject(w http.ResponseWriter, r *http.Request, blobs blob.Store, withBody bool) {
+
+	bucket, key := chi.URLParam(r, "bucket"), chi.URLParam(r, "*")
+	object, data, err := blobs.Get(bucket, key, r.URL.Query().Get("versionId"))
+	if err != nil {
+		if err == blob.ErrObjectNotFound {
+			if !withBody {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeS3Error(w, http.StatusNotFound, S3Error{Code: "NoSuchKey", Message: "The specified key does not exist.", Key: key})
+			return
+		}
+
+		writeS3Error(w, http.StatusInternalServerError, S3Error{Code: "InternalError", Message: "We encountered an internal error. Please try again."})
+		log.Printf("Getting object: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "binary/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	w.Header().Set("ETag", `"`+object.ETag+`"`)
+	w.Header().Set("Last-Modified", object.LastModified.Format(http.TimeFormat))
+	if object.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", object.VersionID)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if withBody {
+		w.Write(data)
+	}
+}
+
+func handleDeleteObject(w http.ResponseWriter, r *http.Request, blobs blob.Store) {
+
+	bucket, key := chi.URLParam(r, "bucket"), chi.URLParam(r, "*")
+	err := blobs.Delete(bucket, key, r.URL.Query().Get("versionId"))
+	if err != nil {
+		writeS3Error(w, http.StatusInternalServerError, S3Error{Code: "InternalError", Message: "We encountered an internal error. Please try again."})
+		log.Printf("Deleting object: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleListObjects(w http.ResponseWriter, r *http.Request, blobs blob.Store) {
+
+	query := r.URL.Query()
+	result := ListBucketResult{
+		Name:              chi.URLParam(r, "bucket"),
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           maxListKeys,
+	}
+
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		n, err := strconv.Atoi(maxKeys)
+		if err != nil || n < 0 {
+			writeS3Error(w, http.StatusBadRequest, S3Error{Code: "InvalidArgument", Message: "Invalid max-keys."})
+			return
+		}
+		if n < maxListKeys {
+			result.MaxKeys = n
+		}
+	}
+
+	// The continuation token is the last key or common prefix returned
+	after := result.StartAfter
+	if result.ContinuationToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, S3Error{Code: "InvalidArgument", Message: "The continuation token provided is incorrect."})
+			return
+		}
+		after = string(token)
+	}
+
+	objects, err := blobs.List(result.Name, result.Prefix)
+	if err != nil {
+		writeS3Error(w, http.StatusInternalServerError, S3Error{Code: "InternalError", Message: "We encountered an internal error. Please try again."})
+		log.Printf("Listing objects: %v", err)
+		return
+	}
+
+	var last string
+	for _, object := range objects {
+		// Keys sharing a prefix up to the delimiter are rolled up into a
+		// single common prefix
+		entry, isPrefix := object.Key, false
+		if result.Delimiter != "" {
+			if i := strings.Index(object.Key[len(result.Prefix):], result.Delimiter); i >= 0 {
+				entry, isPrefix = object.Key[:len(result.Prefix)+i+len(result.Delimiter)], true
+			}
+		}
+
+		if entry <= after || (isPrefix && entry == last) {
+			continue
+		}
+
+		if result.KeyCount == result.MaxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+			break
+		}
+
+		if isPrefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, CommonPrefix{Prefix: entry})
+		} else {
+			result.Contents = append(result.Contents, S3Object{
+				Key:          object.Key,
+				LastModified: object.LastModified.Format("2006-01-02T15:04:05.000Z"),
+				ETag:         `"` + object.ETag + `"`,
+				Size:         object.Size,
+				StorageClass: "STANDARD",
+			})
+		}
+		last = entry
+		result.KeyCount++
+	}
+
+	resultXML, err := xml.Marshal(result)
+	if err != nil {
+		writeS3Error(w, http.StatusInternalServerError, S3Error{Code: "InternalError", Message: "We encountered an internal error. Please try again."})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(resultXML)
+}
+
+// writeS3Error writes an S3 error that the AWS SDKs can decode.
+func writeS3Error(w http.ResponseWriter, status int, s3Error S3Error) {
+
+	errorXML, err := xml.Marshal(s3Error)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(errorXML)
+}
+
+// decodeAWSChunked decodes an aws-chunked body: chunks made of a hex size,
+// optional ;chunk-signature=... extension, CRLF, data and CRLF, ended by a
+// zero sized chunk followed by optional trailers that are ignored.
+func decodeAWSChunked(body []byte) ([]byte, error) {
+
+	var data []byte
+	reader := bufio.NewReader(bytes.NewReader(body))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		sizeHex, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid chunk size %q", sizeHex)
+		}
+		if size == 0 {
+			return data, nil
+		}
+
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(reader, chunk)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(chunk, []byte("\r\n")) {
+			return nil, fmt.Errorf("chunk is not terminated by CRLF")
+		}
+
+		data = append(data, chunk[:size]...)
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pablo-ruth/terraform-state-locker/blob"
+)
+
+func TestS3(t *testing.T) {
+
+	server := httptest.NewServer(s3Router(blob.NewInMemoryStore(false)))
+	defer server.Close()
+
+	do := func(method, target, body string, header http.Header) (*http.Response, string) {
+		req, err := http.NewRequest(method, server.URL+target, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+
+		return resp, string(data)
+	}
+
+	resp, _ := do("PUT", "/tfstates/network.tfstate", `{"version":4}`, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == "" {
+		t.Errorf("Unexpected PutObject response %d %v", resp.StatusCode, resp.Header)
+	}
+
+	chunked := "d;chunk-signature=0\r\n{\"version\":4}\r\n0\r\nx-amz-checksum-crc32:AAAAAA==\r\n\r\n"
+	resp, _ = do("PUT", "/tfstates/env:/dev/network.tfstate", chunked, http.Header{"Content-Encoding": {"aws-chunked"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Unexpected chunked PutObject response %d", resp.StatusCode)
+	}
+
+	resp, _ = do("PUT", "/tfstates/env:/prod/network.tfstate", `{"version":4}`, http.Header{"Content-Md5": {"AAAAAAAAAAAAAAAAAAAAAA=="}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a bad digest error, got %d", resp.StatusCode)
+	}
+
+	resp, body := do("GET", "/tfstates/env:/dev/network.tfstate", "", nil)
+	if resp.StatusCode != http.StatusOK || body != `{"version":4}` {
+		t.Errorf("Unexpected GetObject response %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do("HEAD", "/tfstates/missing.tfstate", "", nil)
+	if resp.StatusCode != http.StatusNotFound || body != "" {
+		t.Errorf("Unexpected HeadObject response %d %s", resp.StatusCode, body)
+	}
+
+	var pages [][]string
+	var token string
+	for {
+		target := "/tfstates?list-type=2&delimiter=/&max-keys=1"
+		if token != "" {
+			target += "&continuation-token=" + token
+		}
+
+		resp, body = do("GET", target, "", nil)
+		var result ListBucketResult
+		if err := xml.Unmarshal([]byte(body), &result); err != nil {
+			t.Fatalf("Unmarshal %s: %v", body, err)
+		}
+
+		var page []string
+		for _, prefix := range result.CommonPrefixes {
+			page = append(page, prefix.Prefix)
+		}
+		for _, object := range result.Contents {
+			page = append(page, object.Key)
+		}
+		pages = append(pages, page)
+
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	expected := [][]string{{"env:/"}, {"network.tfstate"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Expected pages %v, got %v", expected, pages)
+	}
+
+	resp, _ = do("DELETE", "/tfstates/network.tfstate", "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Unexpected DeleteObject response %d", resp.StatusCode)
+	}
+
+	resp, body = do("GET", "/tfstates/network.tfstate", "", nil)
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "<Code>NoSuchKey</Code>") {
+		t.Errorf("Unexpected GetObject response %d %s", resp.StatusCode, body)
+	}
+}
@@ -4,37 +4,152 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/pablo-ruth/terraform-state-locker/blob"
 	"github.com/pablo-ruth/terraform-state-locker/store"
+	"github.com/pablo-ruth/terraform-state-locker/webhook"
 )
 
-func Serve(addr, cert, key string, store store.Store) error {
+// Options are the optional behaviors of the server.
+type Options struct {
+	// OwnerCheck rejects the release of a lock by an identity other than the
+	// one that acquired it.
+	OwnerCheck bool
+	// HTTPLockTable is the table holding the locks of the http backend,
+	// usually the DynamoDB lock table of the S3 backend.
+	HTTPLockTable string
+	// HTTPStateTable is the table holding the states of the http backend.
+	HTTPStateTable string
+	// BlobStore serves the S3 API under /s3 when set.
+	BlobStore blob.Store
+	// AdminToken serves the admin API under /admin, the fencing token checks
+	// under /fencing and the metrics under /metrics when set, requests must
+	// carry it as a bearer token.
+	AdminToken string
+	// LockQueueTTL enables fair FIFO ordering of lock acquisitions when not
+	// zero: a caller failing to acquire a lock is queued, and loses its place
+	// when it does not retry within LockQueueTTL.
+	LockQueueTTL time.Duration
+	// FreezeWindows are the scheduled change freezes, more can be added
+	// through the admin API. In a Cluster, those are added to the leader
+	// and not replicated, they are lost when leadership changes.
+	FreezeWindows []FreezeWindow
+	// Webhooks are notified of lock lifecycle events when set.
+	Webhooks *webhook.Dispatcher
+	// EventsHeldAfter enables the expiry events of the admin event stream
+	// when not zero, sent once for every lock held for longer.
+	EventsHeldAfter time.Duration
+	// Cluster is set when the store is replicated, followers then forward
+	// the DynamoDB requests that need the leader.
+	Cluster Cluster
+	// ClusterToken authenticates the requests forwarded between the nodes of
+	// the Cluster.
+	ClusterToken string
+	// Replication is set when the store is replicated asynchronously, it is
+	// then the store served. A replica rejects writes until it is promoted
+	// through the admin API.
+	Replication *store.ReplicatedStore
+	// ReplicationToken serves the replication stream under /replication when
+	// set, replicas must carry it as a bearer token.
+	ReplicationToken string
+}
+
+func Serve(addr, cert, key string, store store.Store, opts Options) error {
+
+	fmt.Printf("Server is running on port https://%s\n", addr)
+	err := http.ListenAndServeTLS(addr, cert, key, newRouter(store, opts))
+
+	return err
+}
+
+// newRouter returns the handler of every API served.
+func newRouter(store store.Store, opts Options) http.Handler {
+	// DynamoDB remembers client request tokens for ten minutes
+	tokens := newTokenCache(10 * time.Minute)
+	// Counting the entries scans every table, once per usual scrape interval
+	gauges := newEntryGauges(15 * time.Second)
+
+	var queue *waitQueue
+	if opts.LockQueueTTL > 0 {
+		queue = newWaitQueue(opts.LockQueueTTL)
+	}
+	freezes := newFreezeSchedule(opts.FreezeWindows)
+	guard := &guard{queue: queue, freezes: freezes}
+
+	// Every write goes through the publisher to feed the event stream
+	publisher := newPublisher(store)
+	store = publisher
+
+	watchExpiries(publisher, opts)
+
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
 	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
 
+		if opts.Cluster != nil && forwardToLeader(w, r, opts.Cluster, opts.ClusterToken) {
+			return
+		}
+		if opts.Replication != nil && rejectOnReplica(w, r, opts.Replication) {
+			return
+		}
+
 		switch r.Header.Get("X-Amz-Target") {
 		case "":
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("X-Amz-Target header is missing"))
 			log.Printf("X-Amz-Target header is missing")
 		case "DynamoDB_20120810.PutItem":
-			handlePutItem(w, r, store)
+			handlePutItem(w, r, store, opts, guard)
+		case "DynamoDB_20120810.UpdateItem":
+			handleUpdateItem(w, r, store, opts, guard)
 		case "DynamoDB_20120810.GetItem":
 			handleGetItem(w, r, store)
 		case "DynamoDB_20120810.DeleteItem":
-			handleDeleteItem(w, r, store)
+			handleDeleteItem(w, r, store, opts)
+		case "DynamoDB_20120810.Scan":
+			handleScan(w, r, store)
+		case "DynamoDB_20120810.Query":
+			handleQuery(w, r, store)
+		case "DynamoDB_20120810.BatchGetItem":
+			handleBatchGetItem(w, r, store)
+		case "DynamoDB_20120810.BatchWriteItem":
+			handleBatchWriteItem(w, r, store, opts, guard)
+		case "DynamoDB_20120810.TransactWriteItems":
+			handleTransactWriteItems(w, r, store, opts, tokens, guard)
+		case "DynamoDB_20120810.TransactGetItems":
+			handleTransactGetItems(w, r, store)
+		case "DynamoDBStreams_20120810.ListStreams":
+			handleListStreams(w, r, publisher)
+		case "DynamoDBStreams_20120810.DescribeStream":
+			handleDescribeStream(w, r, publisher)
+		case "DynamoDBStreams_20120810.GetShardIterator":
+			handleGetShardIterator(w, r, publisher)
+		case "DynamoDBStreams_20120810.GetRecords":
+			handleGetRecords(w, r, publisher)
 		default:
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("Unknown X-Amz-Target header"))
 			log.Printf("Unknown X-Amz-Target header")
 		}
 	})
+	r.Mount("/state", httpBackendRouter(store, opts, guard))
+	if opts.BlobStore != nil {
+		r.Mount("/s3", s3Router(opts.BlobStore))
+	}
+	if opts.Replication != nil && opts.ReplicationToken != "" {
+		r.Mount("/replication", replicationRouter(opts.Replication, opts.ReplicationToken))
+	}
+	if opts.AdminToken != "" {
+		r.Mount("/admin", adminRouter(publisher, opts, queue, freezes))
+		r.Mount("/fencing", fencingRouter(store, opts.AdminToken))
+		r.With(requireBearer(opts.AdminToken)).Get("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			handleMetrics(w, r, store, opts, gauges)
+		})
+	}
 
-	fmt.Printf("Server is running on port https://%s\n", addr)
-	err := http.ListenAndServeTLS(addr, cert, key, r)
-
-	return err
+	return r
 }
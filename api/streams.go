@@ -0,0 +1,274 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+// Every table has a stream made of a single shard that never closes.
+const (
+	streamRegion     = "us-east-1"
+	streamAccount    = "000000000000"
+	streamShardID    = "shardId-00000000000000000001-00000001"
+	streamViewType   = "NEW_AND_OLD_IMAGES"
+	maxStreamRecords = 1000
+)
+
+var streamEventNames = map[store.ChangeType]string{
+	store.ChangeAcquire: "INSERT",
+	store.ChangeUpdate:  "MODIFY",
+	store.ChangeRelease: "REMOVE",
+}
+
+func streamLabel(p *store.Publisher) string {
+	return p.Created().Format("2006-01-02T15:04:05.000")
+}
+
+func streamArn(p *store.Publisher, table string) string {
+	return fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/%s/stream/%s", streamRegion, streamAccount, table, streamLabel(p))
+}
+
+// streamTable returns the table of a stream ARN, if it designates a stream of
+// p.
+func streamTable(p *store.Publisher, arn string) (string, bool) {
+
+	prefix := fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/", streamRegion, streamAccount)
+	suffix := "/stream/" + streamLabel(p)
+	if !strings.HasPrefix(arn, prefix) || !strings.HasSuffix(arn, suffix) || len(arn) <= len(prefix)+len(suffix) {
+		return "", false
+	}
+
+	return arn[len(prefix) : len(arn)-len(suffix)], true
+}
+
+// Shard iterators are opaque to clients, they encode the table and the
+// sequence number of the last record read.
+func encodeShardIterator(table string, afterSeq uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(table + "\x00" + strconv.FormatUint(afterSeq, 10)))
+}
+
+func decodeShardIterator(iterator string) (string, uint64, error) {
+
+	decoded, err := base64.RawURLEncoding.DecodeString(iterator)
+	if err != nil {
+		return "", 0, err
+	}
+
+	table, seq, ok := strings.Cut(string(decoded), "\x00")
+	if !ok {
+		return "", 0, fmt.Errorf("invalid shard iterator")
+	}
+
+	afterSeq, err := strconv.ParseUint(seq, 10, 64)
+
+	return table, afterSeq, err
+}
+
+func formatSequenceNumber(seq uint64) string {
+	return fmt.Sprintf("%021d", seq)
+}
+
+func handleListStreams(w http.ResponseWriter, r *http.Request, p *store.Publisher) {
+
+	listStreamsRequest, err := ParseListStreamsRequest(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	limit := listStreamsRequest.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+
+	tables, err := p.Tables(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		log.Printf("Listing tables: %v", err)
+		return
+	}
+
+	var resp ListStreamsResponse
+	resp.Streams = []Stream{}
+	for _, table := range tables {
+		arn := streamArn(p, table)
+		if listStreamsRequest.TableName != "" && table != listStreamsRequest.TableName {
+			continue
+		}
+		if listStreamsRequest.ExclusiveStartStreamArn != "" && arn <= listStreamsRequest.ExclusiveStartStreamArn {
+			continue
+		}
+
+		if len(resp.Streams) == limit {
+			resp.LastEvaluatedStreamArn = resp.Streams[len(resp.Streams)-1].StreamArn
+			break
+		}
+		resp.Streams = append(resp.Streams, Stream{StreamArn: arn, StreamLabel: streamLabel(p), TableName: table})
+	}
+
+	writeStreamsResponse(w, resp)
+}
+
+func handleDescribeStream(w http.ResponseWriter, r *http.Request, p *store.Publisher) {
+
+	describeStreamRequest, err := ParseDescribeStreamRequest(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	table, ok := streamTable(p, describeStreamRequest.StreamArn)
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrorResponse{Type: "ResourceNotFoundException", Message: "Requested resource not found: Stream not found"})
+		return
+	}
+
+	resp := DescribeStreamResponse{
+		StreamDescription: StreamDescription{
+			StreamArn:               describeStreamRequest.StreamArn,
+			StreamLabel:             streamLabel(p),
+			StreamStatus:            "ENABLED",
+			StreamViewType:          streamViewType,
+			CreationRequestDateTime: float64(p.Created().UnixNano()) / 1e9,
+			TableName:               table,
+			KeySchema:               []KeySchemaElement{{AttributeName: "LockID", KeyType: "HASH"}},
+			Shards:                  []Shard{},
+		},
+	}
+	if describeStreamRequest.ExclusiveStartShardId < streamShardID {
+		resp.StreamDescription.Shards = append(resp.StreamDescription.Shards, Shard{
+			ShardId:             streamShardID,
+			SequenceNumberRange: SequenceNumberRange{StartingSequenceNumber: formatSequenceNumber(p.StreamStart(table))},
+		})
+	}
+
+	writeStreamsResponse(w, resp)
+}
+
+func handleGetShardIterator(w http.ResponseWriter, r *http.Request, p *store.Publisher) {
+
+	getShardIteratorRequest, err := ParseGetShardIteratorRequest(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	table, ok := streamTable(p, getShardIteratorRequest.StreamArn)
+	if !ok || getShardIteratorRequest.ShardId != streamShardID {
+		writeError(w, http.StatusBadRequest, ErrorResponse{Type: "ResourceNotFoundException", Message: "Requested resource not found: Shard does not exist"})
+		return
+	}
+
+	var afterSeq uint64
+	switch getShardIteratorRequest.ShardIteratorType {
+	case "TRIM_HORIZON":
+		afterSeq = p.StreamStart(table) - 1
+	case "LATEST":
+		afterSeq = p.LastSeq()
+	case "AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER":
+		seq, err := strconv.ParseUint(getShardIteratorRequest.SequenceNumber, 10, 64)
+		if err != nil || seq == 0 {
+			writeError(w, http.StatusBadRequest, ErrorResponse{Type: "ValidationException", Message: "Invalid SequenceNumber"})
+			return
+		}
+		afterSeq = seq
+		if getShardIteratorRequest.ShardIteratorType == "AT_SEQUENCE_NUMBER" {
+			afterSeq = seq - 1
+		}
+	default:
+		writeError(w, http.StatusBadRequest, ErrorResponse{Type: "ValidationException", Message: "Invalid ShardIteratorType"})
+		return
+	}
+
+	writeStreamsResponse(w, GetShardIteratorResponse{ShardIterator: encodeShardIterator(table, afterSeq)})
+}
+
+func handleGetRecords(w http.ResponseWriter, r *http.Request, p *store.Publisher) {
+
+	getRecordsRequest, err := ParseGetRecordsRequest(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		log.Printf("Parsing request: %v", err)
+		return
+	}
+
+	table, afterSeq, err := decodeShardIterator(getRecordsRequest.ShardIterator)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrorResponse{Type: "ValidationException", Message: "Invalid ShardIterator"})
+		return
+	}
+
+	limit := getRecordsRequest.Limit
+	if limit <= 0 || limit > maxStreamRecords {
+		limit = maxStreamRecords
+	}
+
+	changes, trimmed := p.StreamRecords(table, afterSeq, limit)
+	if trimmed {
+		writeError(w, http.StatusBadRequest, ErrorResponse{Type: "TrimmedDataAccessException", Message: "The operation attempted to read past the oldest stream record in a shard"})
+		return
+	}
+
+	resp := GetRecordsResponse{Records: []Record{}}
+	for _, change := range changes {
+		record := Record{
+			EventID:      strconv.FormatUint(change.Seq, 10),
+			EventName:    streamEventNames[change.Type],
+			EventVersion: "1.1",
+			EventSource:  "aws:dynamodb",
+			AwsRegion:    streamRegion,
+			Dynamodb: StreamRecord{
+				ApproximateCreationDateTime: float64(change.Time.Unix()),
+				Keys:                        map[string]Item{"LockID": {S: change.ID}},
+				SequenceNumber:              formatSequenceNumber(change.Seq),
+				StreamViewType:              streamViewType,
+			},
+		}
+		if change.Type != store.ChangeRelease {
+			record.Dynamodb.NewImage = toItem(change.ID, change.Attributes, nil)
+		}
+		if change.Old != nil {
+			record.Dynamodb.OldImage = toItem(change.ID, change.Old, nil)
+		}
+		for _, image := range []map[string]Item{record.Dynamodb.Keys, record.Dynamodb.NewImage, record.Dynamodb.OldImage} {
+			for k, v := range image {
+				record.Dynamodb.SizeBytes += len(k) + len(v.S)
+			}
+		}
+
+		resp.Records = append(resp.Records, record)
+		afterSeq = change.Seq
+	}
+	resp.NextShardIterator = encodeShardIterator(table, afterSeq)
+
+	writeStreamsResponse(w, resp)
+}
+
+func writeStreamsResponse(w http.ResponseWriter, resp interface{}) {
+
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+func TestStreams(t *testing.T) {
+
+	ctx := context.Background()
+	p := newPublisher(store.NewInMemoryStore())
+	_, _, err := p.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}, store.NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, _, err = p.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test2"}}, nil)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, err = p.Delete(ctx, "terraform-lock-table", "tfstates/network", nil)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	call := func(handler func(http.ResponseWriter, *http.Request, *store.Publisher), body string, resp interface{}) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/", strings.NewReader(body)), p)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d %s", w.Code, w.Body)
+		}
+		json.Unmarshal(w.Body.Bytes(), resp)
+	}
+
+	var listStreamsResponse ListStreamsResponse
+	call(handleListStreams, `{"TableName":"terraform-lock-table"}`, &listStreamsResponse)
+	if len(listStreamsResponse.Streams) != 1 {
+		t.Fatalf("Expected 1 stream, got %v", listStreamsResponse.Streams)
+	}
+	arn := listStreamsResponse.Streams[0].StreamArn
+
+	cases := []struct {
+		name         string
+		iteratorType string
+		expected     []Record
+	}{
+		{
+			name:         "read from the oldest record",
+			iteratorType: "TRIM_HORIZON",
+			expected: []Record{
+				{EventName: "INSERT", Dynamodb: StreamRecord{
+					NewImage: map[string]Item{"LockID": {S: "tfstates/network"}, "Info": {S: "Test"}, "FencingToken": {S: "1"}},
+				}},
+				{EventName: "MODIFY", Dynamodb: StreamRecord{
+					NewImage: map[string]Item{"LockID": {S: "tfstates/network"}, "Info": {S: "Test2"}, "FencingToken": {S: "1"}},
+					OldImage: map[string]Item{"LockID": {S: "tfstates/network"}, "Info": {S: "Test"}, "FencingToken": {S: "1"}},
+				}},
+				{EventName: "REMOVE", Dynamodb: StreamRecord{
+					OldImage: map[string]Item{"LockID": {S: "tfstates/network"}, "Info": {S: "Test2"}, "FencingToken": {S: "1"}},
+				}},
+			},
+		},
+		{
+			name:         "read from the latest record",
+			iteratorType: "LATEST",
+			expected:     []Record{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var getShardIteratorResponse GetShardIteratorResponse
+			call(handleGetShardIterator, `{"StreamArn":"`+arn+`","ShardId":"`+streamShardID+`","ShardIteratorType":"`+c.iteratorType+`"}`, &getShardIteratorResponse)
+
+			var getRecordsResponse GetRecordsResponse
+			call(handleGetRecords, `{"ShardIterator":"`+getShardIteratorResponse.ShardIterator+`"}`, &getRecordsResponse)
+
+			records := []Record{}
+			for _, record := range getRecordsResponse.Records {
+				records = append(records, Record{EventName: record.EventName, Dynamodb: StreamRecord{
+					NewImage: record.Dynamodb.NewImage,
+					OldImage: record.Dynamodb.OldImage,
+				}})
+			}
+
+			if !reflect.DeepEqual(records, c.expected) {
+				t.Errorf("Expected %v, got %v", c.expected, records)
+			}
+		})
+	}
+}
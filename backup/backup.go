@@ -0,0 +1,273 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+// Version is the version of the archives written, Read rejects the others.
+const Version = 1
+
+// Archive is a backup of every table of a store.
+type Archive struct {
+	Version int       `json:"Version"`
+	Created time.Time `json:"Created"`
+	Tables  []Table   `json:"Tables"`
+}
+
+type Table struct {
+	Name    string  `json:"Name"`
+	Entries []Entry `json:"Entries"`
+}
+
+// Entry is an entry of a table. FencingToken is the token the entry held and
+// Created and Updated are when it was created and last written, missing when
+// the store did not record them. They are kept for reference: the store
+// restoring the entry assigns its own.
+type Entry struct {
+	ID           string            `json:"ID"`
+	Attributes   map[string]string `json:"Attributes"`
+	Owner        string            `json:"Owner,omitempty"`
+	FencingToken uint64            `json:"FencingToken,omitempty"`
+	Created      *time.Time        `json:"Created,omitempty"`
+	Updated      *time.Time        `json:"Updated,omitempty"`
+}
+
+type Mode string
+
+const (
+	// Merge restores the entries of the archive, keeping the others.
+	Merge Mode = "merge"
+	// Replace restores the entries of the archive and deletes the others, in
+	// every table.
+	Replace Mode = "replace"
+)
+
+func ParseMode(s string) (Mode, error) {
+
+	switch Mode(s) {
+	case Merge, Replace:
+		return Mode(s), nil
+	}
+
+	return "", fmt.Errorf("unknown import mode %q", s)
+}
+
+type Action string
+
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+// Change is a change made, or that would be made, by Import.
+type Change struct {
+	Action Action `json:"Action"`
+	Table  string `json:"Table"`
+	ID     string `json:"ID"`
+}
+
+// Export returns a backup of every table of s. The entries listed are read
+// from a single snapshot with TransactGet, entries created while they are
+// listed may be missing.
+func Export(ctx context.Context, s store.Store) (*Archive, error) {
+
+	archive := &Archive{Version: Version, Created: time.Now().UTC(), Tables: []Table{}}
+
+	tableNames, err := s.Tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []store.Key
+	for _, table := range tableNames {
+		entries, err := s.Scan(ctx, table, "", 0)
+		if err != nil && !errors.Is(err, store.ErrTableNotFound) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			keys = append(keys, store.Key{Table: table, ID: entry.ID})
+		}
+	}
+
+	values, err := s.TransactGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := map[string]*Table{}
+	for _, table := range tableNames {
+		archive.Tables = append(archive.Tables, Table{Name: table, Entries: []Entry{}})
+		tables[table] = &archive.Tables[len(archive.Tables)-1]
+	}
+	for i, key := range keys {
+		if values[i] == nil {
+			continue
+		}
+
+		entry := Entry{ID: key.ID, Attributes: values[i].Attributes, Owner: values[i].Owner, Created: recorded(values[i].Created), Updated: recorded(values[i].Updated)}
+		if token, ok := entry.Attributes[store.FencingTokenAttribute]; ok {
+			entry.FencingToken, _ = strconv.ParseUint(token, 10, 64)
+			delete(entry.Attributes, store.FencingTokenAttribute)
+		}
+		tables[key.Table].Entries = append(tables[key.Table].Entries, entry)
+	}
+
+	return archive, nil
+}
+
+// recorded returns t, nil when it is zero.
+func recorded(t time.Time) *time.Time {
+
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// Write writes archive as JSON, gzipped when compress is set.
+func Write(w io.Writer, archive *Archive, compress bool) error {
+
+	if !compress {
+		return json.NewEncoder(w).Encode(archive)
+	}
+
+	gz := gzip.NewWriter(w)
+	err := json.NewEncoder(gz).Encode(archive)
+	if err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// Read reads an archive written by Write, gzipped or not.
+func Read(r io.Reader) (*Archive, error) {
+
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = buffered
+	}
+
+	var archive Archive
+	err := json.NewDecoder(r).Decode(&archive)
+	if err != nil {
+		return nil, err
+	}
+	if archive.Version != Version {
+		return nil, fmt.Errorf("unsupported archive version %d", archive.Version)
+	}
+
+	return &archive, nil
+}
+
+// Import restores archive into s and returns the changes made, or only
+// lists them when dryRun is set. Entries identical to those of the archive,
+// fencing token aside, are left alone. Restored locks get new fencing tokens
+// from s.
+//
+// Each table is restored atomically with a single TransactWrite, one table
+// after the other: when Import fails, the tables restored so far are
+// returned in the changes and the others are left untouched. An entry
+// changed since the archive was made is overwritten like any other.
+func Import(ctx context.Context, s store.Store, archive *Archive, mode Mode, dryRun bool) ([]Change, error) {
+
+	tables, err := s.Tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	current := map[store.Key]store.Entry{}
+	scanned := map[string][]store.Entry{}
+	for _, table := range tables {
+		entries, err := s.Scan(ctx, table, "", 0)
+		if err != nil && !errors.Is(err, store.ErrTableNotFound) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			delete(entry.Attributes, store.FencingTokenAttribute)
+			current[store.Key{Table: table, ID: entry.ID}] = entry
+		}
+		scanned[table] = entries
+	}
+
+	// The changes and ops of every table, in the order tables are restored
+	var order []string
+	tableChanges := map[string][]Change{}
+	ops := map[string][]store.WriteOp{}
+	add := func(change Change, op store.WriteOp) {
+		if _, ok := ops[change.Table]; !ok {
+			order = append(order, change.Table)
+		}
+		tableChanges[change.Table] = append(tableChanges[change.Table], change)
+		ops[change.Table] = append(ops[change.Table], op)
+	}
+
+	restored := map[store.Key]bool{}
+	for _, table := range archive.Tables {
+		for _, entry := range table.Entries {
+			key := store.Key{Table: table.Name, ID: entry.ID}
+			restored[key] = true
+
+			change := Change{Action: Create, Table: table.Name, ID: entry.ID}
+			if existing, ok := current[key]; ok {
+				if equal(existing.Attributes, entry.Attributes) && existing.Owner == entry.Owner {
+					continue
+				}
+				change.Action = Update
+			}
+
+			add(change, store.WriteOp{Kind: store.WritePut, Table: table.Name, ID: entry.ID, Attributes: entry.Attributes, Owner: entry.Owner})
+		}
+	}
+
+	if mode == Replace {
+		for _, table := range tables {
+			for _, entry := range scanned[table] {
+				if restored[store.Key{Table: table, ID: entry.ID}] {
+					continue
+				}
+
+				add(Change{Action: Delete, Table: table, ID: entry.ID}, store.WriteOp{Kind: store.WriteDelete, Table: table, ID: entry.ID})
+			}
+		}
+	}
+
+	changes := []Change{}
+	for _, table := range order {
+		if !dryRun {
+			err := s.TransactWrite(ctx, ops[table])
+			if err != nil {
+				return changes, err
+			}
+		}
+		changes = append(changes, tableChanges[table]...)
+	}
+
+	return changes, nil
+}
+
+func equal(a, b map[string]string) bool {
+	return len(a) == 0 && len(b) == 0 || reflect.DeepEqual(a, b)
+}
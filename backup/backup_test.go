@@ -0,0 +1,202 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+)
+
+func TestExportRead(t *testing.T) {
+
+	ctx := context.Background()
+	s := store.NewInMemoryStore()
+	_, lock, _ := s.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}, Owner: "AKIDALICE"}, store.NotExists)
+	_, digest, _ := s.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/network-md5", Attributes: map[string]string{"Digest": "00000000000000000000000000000000"}}, nil)
+
+	archive, err := Export(ctx, s)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	expected := []Table{
+		{
+			Name: "terraform-lock-table",
+			Entries: []Entry{
+				{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}, Owner: "AKIDALICE", FencingToken: 1, Created: &lock.Created, Updated: &lock.Updated},
+				{ID: "tfstates/network-md5", Attributes: map[string]string{"Digest": "00000000000000000000000000000000"}, Created: &digest.Created, Updated: &digest.Updated},
+			},
+		},
+	}
+	if !reflect.DeepEqual(archive.Tables, expected) {
+		t.Errorf("Expected %v, got %v", expected, archive.Tables)
+	}
+
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		err = Write(&buf, archive, compress)
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+
+		read, err := Read(&buf)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if !reflect.DeepEqual(read.Tables, expected) || !read.Created.Equal(archive.Created) {
+			t.Errorf("Expected %v, got %v", archive, read)
+		}
+	}
+
+	_, err = Read(strings.NewReader(`{"Version":2,"Tables":[]}`))
+	if err == nil {
+		t.Errorf("Expected an error for an unsupported version")
+	}
+}
+
+func TestImport(t *testing.T) {
+
+	ctx := context.Background()
+	archive := &Archive{
+		Version: Version,
+		Tables: []Table{
+			{
+				Name: "terraform-lock-table",
+				Entries: []Entry{
+					{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}, Owner: "AKIDALICE", FencingToken: 7},
+					{ID: "tfstates/network-md5", Attributes: map[string]string{"Digest": "00000000000000000000000000000000"}},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		name            string
+		mode            Mode
+		dryRun          bool
+		expectedChanges []Change
+		expectedEntries []store.Entry
+	}{
+		{
+			name: "merge",
+			mode: Merge,
+			expectedChanges: []Change{
+				{Action: Update, Table: "terraform-lock-table", ID: "tfstates/network"},
+				{Action: Create, Table: "terraform-lock-table", ID: "tfstates/network-md5"},
+			},
+			expectedEntries: []store.Entry{
+				{ID: "tfstates/dns", Kind: store.KindLock, Attributes: map[string]string{"Info": "Other", "FencingToken": "1"}},
+				{ID: "tfstates/network", Kind: store.KindLock, Attributes: map[string]string{"Info": "Test", "FencingToken": "1"}, Owner: "AKIDALICE"},
+				{ID: "tfstates/network-md5", Kind: store.KindDigest, Attributes: map[string]string{"Digest": "00000000000000000000000000000000"}},
+			},
+		},
+		{
+			name: "replace",
+			mode: Replace,
+			expectedChanges: []Change{
+				{Action: Update, Table: "terraform-lock-table", ID: "tfstates/network"},
+				{Action: Create, Table: "terraform-lock-table", ID: "tfstates/network-md5"},
+				{Action: Delete, Table: "terraform-lock-table", ID: "tfstates/dns"},
+			},
+			expectedEntries: []store.Entry{
+				{ID: "tfstates/network", Kind: store.KindLock, Attributes: map[string]string{"Info": "Test", "FencingToken": "1"}, Owner: "AKIDALICE"},
+				{ID: "tfstates/network-md5", Kind: store.KindDigest, Attributes: map[string]string{"Digest": "00000000000000000000000000000000"}},
+			},
+		},
+		{
+			name:   "dry run",
+			mode:   Replace,
+			dryRun: true,
+			expectedChanges: []Change{
+				{Action: Update, Table: "terraform-lock-table", ID: "tfstates/network"},
+				{Action: Create, Table: "terraform-lock-table", ID: "tfstates/network-md5"},
+				{Action: Delete, Table: "terraform-lock-table", ID: "tfstates/dns"},
+			},
+			expectedEntries: []store.Entry{
+				{ID: "tfstates/dns", Kind: store.KindLock, Attributes: map[string]string{"Info": "Other", "FencingToken": "1"}},
+				{ID: "tfstates/network", Kind: store.KindLock, Attributes: map[string]string{"Info": "Stale", "FencingToken": "1"}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := store.NewInMemoryStore()
+			s.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Stale"}}, store.NotExists)
+			s.Put(ctx, "terraform-lock-table", store.Item{ID: "tfstates/dns", Attributes: map[string]string{"Info": "Other"}}, store.NotExists)
+
+			changes, err := Import(ctx, s, archive, c.mode, c.dryRun)
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if !reflect.DeepEqual(changes, c.expectedChanges) {
+				t.Errorf("Expected %v, got %v", c.expectedChanges, changes)
+			}
+
+			// The store assigns the times of the entries restored
+			entries, _ := s.Scan(ctx, "terraform-lock-table", "", 0)
+			for i := range entries {
+				entries[i].Created, entries[i].Updated = time.Time{}, time.Time{}
+			}
+			if !reflect.DeepEqual(entries, c.expectedEntries) {
+				t.Errorf("Expected %v, got %v", c.expectedEntries, entries)
+			}
+
+			// Importing again changes nothing
+			if !c.dryRun {
+				changes, _ = Import(ctx, s, archive, c.mode, false)
+				if len(changes) != 0 {
+					t.Errorf("Expected no changes, got %v", changes)
+				}
+			}
+		})
+	}
+}
+
+// failingStore fails the transactions writing to table.
+type failingStore struct {
+	store.Store
+	table string
+}
+
+func (s *failingStore) TransactWrite(ctx context.Context, ops []store.WriteOp) error {
+	if ops[0].Table == s.table {
+		return errors.New("unavailable")
+	}
+	return s.Store.TransactWrite(ctx, ops)
+}
+
+func TestImportFailure(t *testing.T) {
+
+	ctx := context.Background()
+	archive := &Archive{
+		Version: Version,
+		Tables: []Table{
+			{Name: "terraform-lock-table", Entries: []Entry{{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}}},
+			{Name: "terraform-http-state", Entries: []Entry{{ID: "tfstates/network", Attributes: map[string]string{"State": "{}"}}}},
+		},
+	}
+
+	s := store.NewInMemoryStore()
+	s.Put(ctx, "terraform-http-state", store.Item{ID: "tfstates/dns", Attributes: map[string]string{"State": "{}"}}, store.NotExists)
+
+	// The table that fails is left untouched, the one before is restored
+	changes, err := Import(ctx, &failingStore{Store: s, table: "terraform-http-state"}, archive, Replace, false)
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+	expected := []Change{{Action: Create, Table: "terraform-lock-table", ID: "tfstates/network"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+
+	entries, _ := s.Scan(ctx, "terraform-http-state", "", 0)
+	if len(entries) != 1 || entries[0].ID != "tfstates/dns" {
+		t.Errorf("Expected %v, got %v", "tfstates/dns", entries)
+	}
+}
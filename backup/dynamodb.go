@@ -0,0 +1,164 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// KeyAttribute is the partition key of lock tables.
+const KeyAttribute = "LockID"
+
+// ReadDynamoDBExport reads the items of a DynamoDB table export in DynamoDB
+// JSON format, as written by ExportTableToPointInTime: one item per line,
+// wrapped in {"Item": ...} or not, gzipped or not. path is either a data
+// file or a directory, whose data files are read in name order and manifest
+// files skipped.
+func ReadDynamoDBExport(path string) ([]Entry, error) {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name := d.Name()
+			if d.IsDir() || strings.HasPrefix(name, "manifest-") || strings.HasSuffix(name, ".md5") {
+				return nil
+			}
+			if strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+
+	entries := []Entry{}
+	for _, file := range files {
+		fileEntries, err := readDynamoDBExportFile(file)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fileEntries...)
+	}
+
+	return entries, nil
+}
+
+func readDynamoDBExportFile(path string) ([]Entry, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	buffered := bufio.NewReader(f)
+	magic, _ := buffered.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = buffered
+	}
+
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	// Items are up to 400KB, escaped as JSON
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		entry, err := parseDynamoDBItem(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return entries, nil
+}
+
+// parseDynamoDBItem converts an item in DynamoDB JSON to an entry. Strings,
+// numbers and booleans are kept as strings, the other types have no
+// equivalent in the store and are rejected rather than lost.
+func parseDynamoDBItem(line []byte) (Entry, error) {
+
+	var item map[string]map[string]json.RawMessage
+	var wrapped struct {
+		Item map[string]map[string]json.RawMessage `json:"Item"`
+	}
+	err := json.Unmarshal(line, &wrapped)
+	if err == nil && wrapped.Item != nil {
+		item = wrapped.Item
+	} else {
+		err = json.Unmarshal(line, &item)
+		if err != nil {
+			return Entry{}, err
+		}
+	}
+
+	entry := Entry{Attributes: map[string]string{}}
+	for name, value := range item {
+		if len(value) != 1 {
+			return Entry{}, fmt.Errorf("attribute %s is not a DynamoDB typed value", name)
+		}
+
+		var s string
+		for typ, raw := range value {
+			switch typ {
+			case "S", "N":
+				err = json.Unmarshal(raw, &s)
+			case "BOOL":
+				var b bool
+				err = json.Unmarshal(raw, &b)
+				s = fmt.Sprint(b)
+			default:
+				err = fmt.Errorf("type %s is not supported", typ)
+			}
+		}
+		if err != nil {
+			return Entry{}, fmt.Errorf("attribute %s: %v", name, err)
+		}
+
+		if name == KeyAttribute {
+			entry.ID = s
+			continue
+		}
+		entry.Attributes[name] = s
+	}
+
+	if entry.ID == "" {
+		return Entry{}, fmt.Errorf("%s is missing", KeyAttribute)
+	}
+
+	return entry, nil
+}
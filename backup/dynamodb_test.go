@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDynamoDBItem(t *testing.T) {
+
+	cases := []struct {
+		name        string
+		line        string
+		expected    Entry
+		expectedErr bool
+	}{
+		{
+			name:     "export line",
+			line:     `{"Item":{"LockID":{"S":"tfstates/network"},"Info":{"S":"{\"ID\":\"1\"}"}}}`,
+			expected: Entry{ID: "tfstates/network", Attributes: map[string]string{"Info": `{"ID":"1"}`}},
+		},
+		{
+			name:     "item line",
+			line:     `{"LockID":{"S":"tfstates/network-md5"},"Digest":{"S":"00000000000000000000000000000000"}}`,
+			expected: Entry{ID: "tfstates/network-md5", Attributes: map[string]string{"Digest": "00000000000000000000000000000000"}},
+		},
+		{
+			name:     "numbers and booleans",
+			line:     `{"Item":{"LockID":{"S":"tfstates/network"},"Expires":{"N":"1672628645"},"Forced":{"BOOL":true}}}`,
+			expected: Entry{ID: "tfstates/network", Attributes: map[string]string{"Expires": "1672628645", "Forced": "true"}},
+		},
+		{
+			name:        "unsupported type",
+			line:        `{"Item":{"LockID":{"S":"tfstates/network"},"Tags":{"SS":["a"]}}}`,
+			expectedErr: true,
+		},
+		{
+			name:        "missing LockID",
+			line:        `{"Item":{"Info":{"S":"Test"}}}`,
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			entry, err := parseDynamoDBItem([]byte(c.line))
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("Expected error %v, got %v", c.expectedErr, err)
+			}
+			if !c.expectedErr && !reflect.DeepEqual(entry, c.expected) {
+				t.Errorf("Expected %v, got %v", c.expected, entry)
+			}
+		})
+	}
+}
+
+func TestReadDynamoDBExport(t *testing.T) {
+
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "data"), 0755)
+	os.WriteFile(filepath.Join(dir, "manifest-summary.json"), []byte(`{"version":"2020-06-30","itemCount":3}`), 0644)
+	os.WriteFile(filepath.Join(dir, "manifest-files.json"), []byte(`{"itemCount":3,"dataFileS3Key":"data/a.json.gz"}`), 0644)
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(`{"Item":{"LockID":{"S":"tfstates/network"},"Info":{"S":"Test"}}}` + "\n" + `{"Item":{"LockID":{"S":"tfstates/network-md5"},"Digest":{"S":"00000000000000000000000000000000"}}}` + "\n"))
+	gz.Close()
+	os.WriteFile(filepath.Join(dir, "data", "a.json.gz"), gzipped.Bytes(), 0644)
+	os.WriteFile(filepath.Join(dir, "data", "b.json"), []byte(`{"LockID":{"S":"tfstates/dns"},"Info":{"S":"Test2"}}`+"\n\n"), 0644)
+
+	entries, err := ReadDynamoDBExport(dir)
+	if err != nil {
+		t.Fatalf("ReadDynamoDBExport: %v", err)
+	}
+
+	expected := []Entry{
+		{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}},
+		{ID: "tfstates/network-md5", Attributes: map[string]string{"Digest": "00000000000000000000000000000000"}},
+		{ID: "tfstates/dns", Attributes: map[string]string{"Info": "Test2"}},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %v, got %v", expected, entries)
+	}
+}
@@ -0,0 +1,198 @@
+package blob
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrObjectNotFound = fmt.Errorf("object not found")
+	ErrInvalidKey     = fmt.Errorf("invalid key")
+)
+
+// Store holds the objects of the S3 API. Buckets exist implicitly.
+type Store interface {
+	// Put stores data under key and returns the new object.
+	Put(bucket, key string, data []byte) (Object, error)
+	// Get returns an object and its data, the latest version when versionID
+	// is empty.
+	Get(bucket, key, versionID string) (Object, []byte, error)
+	// Delete removes an object. On a versioned store, deleting without a
+	// versionID hides the object behind a delete marker while deleting with
+	// one removes that version for good.
+	Delete(bucket, key, versionID string) error
+	// List returns the latest version of the objects of bucket whose key
+	// starts with prefix, sorted by key.
+	List(bucket, prefix string) ([]Object, error)
+}
+
+// Object describes a stored object. VersionID is empty on unversioned
+// stores.
+type Object struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	VersionID    string
+}
+
+// ETag returns the ETag of data, its hex encoded MD5 like S3 for single part
+// uploads.
+func ETag(data []byte) string {
+	digest := md5.Sum(data)
+	return hex.EncodeToString(digest[:])
+}
+
+type InMemoryStore struct {
+	mu        sync.Mutex
+	versioned bool
+	sequence  int
+	buckets   map[string]map[string][]inMemoryVersion
+}
+
+// inMemoryVersion is a version of an object, the last one of a key being the
+// latest. Unversioned stores keep a single version per key.
+type inMemoryVersion struct {
+	object       Object
+	data         []byte
+	deleteMarker bool
+}
+
+func NewInMemoryStore(versioned bool) *InMemoryStore {
+	return &InMemoryStore{
+		versioned: versioned,
+		buckets:   make(map[string]map[string][]inMemoryVersion),
+	}
+}
+
+func (s *InMemoryStore) Put(bucket, key string, data []byte) (Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key == "" {
+		return Object{}, ErrInvalidKey
+	}
+
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		objects = make(map[string][]inMemoryVersion)
+		s.buckets[bucket] = objects
+	}
+
+	version := inMemoryVersion{
+		object: Object{
+			Key:          key,
+			Size:         int64(len(data)),
+			ETag:         ETag(data),
+			LastModified: time.Now().UTC(),
+		},
+		data: append([]byte(nil), data...),
+	}
+
+	if !s.versioned {
+		objects[key] = []inMemoryVersion{version}
+		return version.object, nil
+	}
+
+	version.object.VersionID = s.nextVersionID()
+	objects[key] = append(objects[key], version)
+
+	return version.object, nil
+}
+
+func (s *InMemoryStore) Get(bucket, key, versionID string) (Object, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := s.buckets[bucket][key]
+	if len(versions) == 0 {
+		return Object{}, nil, ErrObjectNotFound
+	}
+
+	if versionID == "" {
+		latest := versions[len(versions)-1]
+		if latest.deleteMarker {
+			return Object{}, nil, ErrObjectNotFound
+		}
+		return latest.object, append([]byte(nil), latest.data...), nil
+	}
+
+	for _, version := range versions {
+		if version.object.VersionID == versionID && !version.deleteMarker {
+			return version.object, append([]byte(nil), version.data...), nil
+		}
+	}
+
+	return Object{}, nil, ErrObjectNotFound
+}
+
+func (s *InMemoryStore) Delete(bucket, key, versionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects := s.buckets[bucket]
+	versions := objects[key]
+	if len(versions) == 0 {
+		return nil
+	}
+
+	if !s.versioned {
+		delete(objects, key)
+		return nil
+	}
+
+	if versionID == "" {
+		if !versions[len(versions)-1].deleteMarker {
+			objects[key] = append(versions, inMemoryVersion{
+				object:       Object{Key: key, VersionID: s.nextVersionID(), LastModified: time.Now().UTC()},
+				deleteMarker: true,
+			})
+		}
+		return nil
+	}
+
+	for i, version := range versions {
+		if version.object.VersionID == versionID {
+			objects[key] = append(versions[:i:i], versions[i+1:]...)
+			break
+		}
+	}
+	if len(objects[key]) == 0 {
+		delete(objects, key)
+	}
+
+	return nil
+}
+
+func (s *InMemoryStore) List(bucket, prefix string) ([]Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []Object
+	for key, versions := range s.buckets[bucket] {
+		latest := versions[len(versions)-1]
+		if !strings.HasPrefix(key, prefix) || latest.deleteMarker {
+			continue
+		}
+
+		result = append(result, latest.object)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+
+	return result, nil
+}
+
+// nextVersionID returns a new version ID sorting after all previous ones.
+// The caller must hold s.mu.
+func (s *InMemoryStore) nextVersionID() string {
+	s.sequence++
+	return fmt.Sprintf("%020d", s.sequence)
+}
@@ -0,0 +1,110 @@
+package blob
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+
+	cases := []struct {
+		name  string
+		store func(t *testing.T) Store
+	}{
+		{
+			name:  "in memory",
+			store: func(t *testing.T) Store { return NewInMemoryStore(false) },
+		},
+		{
+			name:  "versioned in memory",
+			store: func(t *testing.T) Store { return NewInMemoryStore(true) },
+		},
+		{
+			name: "directory",
+			store: func(t *testing.T) Store {
+				s, err := NewDirStore(t.TempDir())
+				if err != nil {
+					t.Fatalf("NewDirStore: %v", err)
+				}
+				return s
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := c.store(t)
+
+			for _, key := range []string{"env:/prod/network.tfstate", "network.tfstate", "env:/dev/network.tfstate"} {
+				_, err := s.Put("tfstates", key, []byte(key))
+				if err != nil {
+					t.Fatalf("Put %s: %v", key, err)
+				}
+			}
+
+			object, data, err := s.Get("tfstates", "network.tfstate", "")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if string(data) != "network.tfstate" || object.Size != 15 || object.ETag != ETag(data) {
+				t.Errorf("Unexpected object %+v with data %q", object, data)
+			}
+
+			objects, err := s.List("tfstates", "env:/")
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			var keys []string
+			for _, object := range objects {
+				keys = append(keys, object.Key)
+			}
+			expected := []string{"env:/dev/network.tfstate", "env:/prod/network.tfstate"}
+			if !reflect.DeepEqual(keys, expected) {
+				t.Errorf("Expected %v, got %v", expected, keys)
+			}
+
+			err = s.Delete("tfstates", "env:/dev/network.tfstate", "")
+			if err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+
+			_, _, err = s.Get("tfstates", "env:/dev/network.tfstate", "")
+			if err != ErrObjectNotFound {
+				t.Errorf("Expected error %v, got %v", ErrObjectNotFound, err)
+			}
+
+			_, err = s.Put("tfstates", "../escape", nil)
+			if c.name == "directory" && err != ErrInvalidKey {
+				t.Errorf("Expected error %v, got %v", ErrInvalidKey, err)
+			}
+		})
+	}
+}
+
+func TestInMemoryStoreVersioning(t *testing.T) {
+
+	s := NewInMemoryStore(true)
+	first, err := s.Put("tfstates", "network.tfstate", []byte("v1"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, err = s.Put("tfstates", "network.tfstate", []byte("v2"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	err = s.Delete("tfstates", "network.tfstate", "")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	_, _, err = s.Get("tfstates", "network.tfstate", "")
+	if err != ErrObjectNotFound {
+		t.Errorf("Expected error %v, got %v", ErrObjectNotFound, err)
+	}
+
+	_, data, err := s.Get("tfstates", "network.tfstate", first.VersionID)
+	if err != nil || string(data) != "v1" {
+		t.Errorf("Expected v1, got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,209 @@
+package blob
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DirStore keeps every object as a file named after its key under a
+// directory per bucket. It does not support versioning.
+type DirStore struct {
+	mu   sync.Mutex
+	root string
+}
+
+func NewDirStore(root string) (*DirStore, error) {
+
+	err := os.MkdirAll(root, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirStore{root: root}, nil
+}
+
+// path returns the file of an object, rejecting buckets and keys that would
+// escape the root directory.
+func (s *DirStore) path(bucket, key string) (string, error) {
+
+	if bucket == "" || strings.HasPrefix(bucket, ".") || strings.ContainsAny(bucket, `/\`) {
+		return "", ErrInvalidKey
+	}
+
+	if key == "" || strings.HasSuffix(key, "/") || strings.Contains(key, `\`) {
+		return "", ErrInvalidKey
+	}
+	for _, element := range strings.Split(key, "/") {
+		if element == "" || element == "." || element == ".." {
+			return "", ErrInvalidKey
+		}
+	}
+
+	return filepath.Join(s.root, bucket, filepath.FromSlash(key)), nil
+}
+
+func (s *DirStore) Put(bucket, key string, data []byte) (Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := s.path(bucket, key)
+	if err != nil {
+		return Object{}, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return Object{}, err
+	}
+
+	// Write to a temporary file outside of the buckets then rename it so that
+	// readers never see a partial object
+	tmp, err := os.CreateTemp(s.root, ".upload-*")
+	if err != nil {
+		return Object{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Object{}, err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return Object{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Object{}, err
+	}
+
+	return Object{
+		Key:          key,
+		Size:         info.Size(),
+		ETag:         ETag(data),
+		LastModified: info.ModTime().UTC(),
+	}, nil
+}
+
+func (s *DirStore) Get(bucket, key, versionID string) (Object, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := s.path(bucket, key)
+	if err != nil || versionID != "" {
+		return Object{}, nil, ErrObjectNotFound
+	}
+
+	object, data, err := s.read(path, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Object{}, nil, ErrObjectNotFound
+	}
+
+	return object, data, err
+}
+
+func (s *DirStore) Delete(bucket, key, versionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := s.path(bucket, key)
+	if err != nil {
+		return nil
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	// Remove the directories left empty, up to the bucket
+	bucketDir := filepath.Join(s.root, bucket)
+	for dir := filepath.Dir(path); dir != bucketDir; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *DirStore) List(bucket, prefix string) ([]Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucketDir := filepath.Join(s.root, bucket)
+	if _, err := s.path(bucket, "key"); err != nil {
+		return nil, nil
+	}
+
+	var result []Object
+	err := filepath.WalkDir(bucketDir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(bucketDir, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		object, _, err := s.read(path, key)
+		if err != nil {
+			return err
+		}
+		result = append(result, object)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+
+	return result, nil
+}
+
+// read returns the object stored in a file. The caller must hold s.mu.
+func (s *DirStore) read(path, key string) (Object, []byte, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Object{}, nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Object{}, nil, err
+	}
+
+	return Object{
+		Key:          key,
+		Size:         int64(len(data)),
+		ETag:         ETag(data),
+		LastModified: info.ModTime().UTC(),
+	}, data, nil
+}
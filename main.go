@@ -1,11 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/pablo-ruth/terraform-state-locker/api"
+	"github.com/pablo-ruth/terraform-state-locker/backup"
+	"github.com/pablo-ruth/terraform-state-locker/blob"
 	"github.com/pablo-ruth/terraform-state-locker/store"
+	"github.com/pablo-ruth/terraform-state-locker/webhook"
+	"github.com/redis/go-redis/v9"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
 func main() {
@@ -13,11 +27,249 @@ func main() {
 	addr := flag.String("l", "0.0.0.0:8000", "Listen address")
 	cert := flag.String("c", "cert.pem", "Path to TLS certificate")
 	key := flag.String("k", "key.pem", "Path to TLS private key")
+	ownerCheck := flag.Bool("owner-check", false, "Only allow a lock to be released by the identity that acquired it")
+	httpLockTable := flag.String("http-lock-table", "terraform-lock-table", "Table holding the locks of the http backend")
+	httpStateTable := flag.String("http-state-table", "terraform-http-state", "Table holding the states of the http backend")
+	s3 := flag.Bool("s3", false, "Serve an S3 API for state objects under /s3")
+	s3Dir := flag.String("s3-dir", "", "Directory holding S3 objects, kept in memory when empty")
+	s3Versioning := flag.Bool("s3-versioning", false, "Keep every version of S3 objects, only for objects kept in memory")
+	adminToken := flag.String("admin-token", os.Getenv("LOCKER_ADMIN_TOKEN"), "Bearer token of the admin API, the fencing token checks and the metrics, disabled when empty (default $LOCKER_ADMIN_TOKEN)")
+	lockQueueTTL := flag.Duration("lock-queue-ttl", 0, "Grant locks to waiting callers in FIFO order, dropping waiters that do not retry within this duration, disabled when 0")
+	freezeFile := flag.String("freeze-file", "", "JSON file listing scheduled change freeze windows")
+	eventsHeldAfter := flag.Duration("events-held-after", time.Hour, "Send an expiry event on the admin event stream for locks held for longer than this duration, disabled when 0")
+	webhookConfig := flag.String("webhook-config", "", "JSON file configuring webhooks notified of lock lifecycle events")
+	raftID := flag.String("raft-id", "", "ID of this node in a Raft replicated cluster, the store is kept in memory on a single node when empty")
+	raftAddr := flag.String("raft-addr", "127.0.0.1:7000", "Address of the Raft transport")
+	raftRPCAddr := flag.String("raft-rpc-addr", "127.0.0.1:7001", "Address other nodes forward writes and join requests to")
+	raftDir := flag.String("raft-dir", "", "Directory holding the Raft log and snapshots, kept in memory when empty")
+	raftBootstrap := flag.Bool("raft-bootstrap", false, "Bootstrap a new Raft cluster made of this node")
+	raftToken := flag.String("raft-token", os.Getenv("LOCKER_RAFT_TOKEN"), "Token authenticating the nodes of a Raft cluster to each other, required with -raft-id (default $LOCKER_RAFT_TOKEN)")
+	raftJoin := flag.String("raft-join", "", "RPC address of a member of the Raft cluster to join")
+	advertiseURL := flag.String("advertise-url", "", "Base URL other nodes of a Raft cluster reach the API of this node at (default https://<-l>)")
+	replicationToken := flag.String("replication-token", os.Getenv("LOCKER_REPLICATION_TOKEN"), "Bearer token of the replication stream, replication is disabled when empty (default $LOCKER_REPLICATION_TOKEN)")
+	replicateFrom := flag.String("replicate-from", "", "Base URL of the primary to replicate from, this server is the primary when empty")
+	replicateCA := flag.String("replicate-ca", "", "PEM file of the CA certificates trusted for the primary, the system ones when empty")
+	boltFile := flag.String("bolt-file", "", "bbolt file holding the tables, kept in memory when empty")
+	sqlDriver := flag.String("sql-driver", "", "Database holding the tables, postgres or sqlite, kept in memory when empty")
+	sqlDSN := flag.String("sql-dsn", os.Getenv("LOCKER_SQL_DSN"), "Data source name of the -sql-driver database (default $LOCKER_SQL_DSN)")
+	redisAddr := flag.String("redis-addr", "", "Comma separated addresses of the Redis server, or of the Sentinels with -redis-master, holding the tables, kept in memory when empty")
+	redisMaster := flag.String("redis-master", "", "Name of the master monitored by the Sentinels at -redis-addr")
+	redisPassword := flag.String("redis-password", os.Getenv("LOCKER_REDIS_PASSWORD"), "Password of the Redis server (default $LOCKER_REDIS_PASSWORD)")
+	redisPrefix := flag.String("redis-prefix", "locker:", "Prefix of the Redis keys")
+	redisTTLAttribute := flag.String("redis-ttl-attribute", "", "Attribute holding the Unix time Redis entries expire at, as the TTL attribute of DynamoDB")
+	importDynamoDB := flag.String("import-dynamodb-export", "", "DynamoDB table export, a data file or the export directory, to load into -import-table of the -bolt-file, -sql-driver or -redis-addr store and exit")
+	importTable := flag.String("import-table", "terraform-lock-table", "Table the DynamoDB table export is loaded into")
+	importDryRun := flag.Bool("import-dry-run", false, "List the changes the DynamoDB table export would make and exit")
 	flag.Parse()
 
-	store := store.NewInMemoryStore()
+	var freezeWindows []api.FreezeWindow
+	if *freezeFile != "" {
+		f, err := os.Open(*freezeFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		freezeWindows, err = api.ParseFreezeWindows(f)
+		f.Close()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	var webhooks *webhook.Dispatcher
+	if *webhookConfig != "" {
+		f, err := os.Open(*webhookConfig)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		config, err := webhook.ParseConfig(f)
+		f.Close()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		webhooks, err = webhook.New(config)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer webhooks.Close()
+	}
+
+	var s store.Store = store.NewInMemoryStore()
+	var backends int
+	for _, backend := range []string{*boltFile, *sqlDriver, *redisAddr} {
+		if backend != "" {
+			backends++
+		}
+	}
+	if backends > 1 {
+		fmt.Println("Only one of -bolt-file, -sql-driver and -redis-addr can be set")
+		return
+	}
+	if backends > 0 && (*raftID != "" || *replicationToken != "") {
+		fmt.Println("Replicated stores are kept in memory, -bolt-file, -sql-driver and -redis-addr cannot be combined with replication")
+		return
+	}
+	// An import is a one-shot migration, it would be lost with a store kept
+	// in memory
+	if *importDynamoDB != "" && backends == 0 && !*importDryRun {
+		fmt.Println("-import-dynamodb-export needs -bolt-file, -sql-driver or -redis-addr, restore a running server through the admin API otherwise")
+		return
+	}
+	if *boltFile != "" {
+
+		boltStore, err := store.NewBoltStore(*boltFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer boltStore.Close()
+		s = boltStore
+	}
+	if *sqlDriver != "" {
+		sqlStore, err := store.NewSQLStore(*sqlDriver, *sqlDSN)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer sqlStore.Close()
+		s = sqlStore
+	}
+	if *redisAddr != "" {
+		// Several addresses make a Cluster client unless they are Sentinels
+		if strings.Contains(*redisAddr, ",") && *redisMaster == "" {
+			fmt.Println("Redis Cluster is not supported, -redis-addr lists several addresses only with -redis-master")
+			return
+		}
+		client := redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:      strings.Split(*redisAddr, ","),
+			MasterName: *redisMaster,
+			Password:   *redisPassword,
+		})
+		// A single address may also be a node of a cluster
+		info, err := client.Info(context.Background(), "cluster").Result()
+		if err != nil {
+			client.Close()
+			fmt.Println(err)
+			return
+		}
+		if strings.Contains(info, "cluster_enabled:1") {
+			client.Close()
+			fmt.Println("Redis Cluster is not supported, -redis-addr is a node of a cluster")
+			return
+		}
+		redisStore := store.NewRedisStore(client, *redisPrefix, *redisTTLAttribute)
+		defer redisStore.Close()
+		s = redisStore
+	}
+	var cluster api.Cluster
+	var replication *store.ReplicatedStore
+	if *raftID != "" && *replicationToken != "" {
+		fmt.Println("Raft and primary/replica replication cannot be combined")
+		return
+	}
+	if *replicationToken != "" {
+		if *replicateFrom == "" {
+			replication = store.NewPrimary()
+		} else {
+			client := &http.Client{}
+			if *replicateCA != "" {
+				pem, err := os.ReadFile(*replicateCA)
+				if err != nil {
+					fmt.Println(err)
+					return
+				}
+				roots := x509.NewCertPool()
+				if !roots.AppendCertsFromPEM(pem) {
+					fmt.Printf("No certificate found in %s\n", *replicateCA)
+					return
+				}
+				client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+			}
+			replication = store.NewReplica(strings.TrimSuffix(*replicateFrom, "/")+"/replication/stream", *replicationToken, client)
+		}
+		s = replication
+	}
+	if *raftID != "" {
+		if *advertiseURL == "" {
+			*advertiseURL = "https://" + *addr
+		}
+
+		raftStore, err := store.NewRaftStore(store.RaftConfig{
+			ID:        *raftID,
+			RaftAddr:  *raftAddr,
+			RPCAddr:   *raftRPCAddr,
+			Dir:       *raftDir,
+			Bootstrap: *raftBootstrap,
+			Join:      *raftJoin,
+			APIAddr:   *advertiseURL,
+			Token:     *raftToken,
+		})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer raftStore.Close()
+		s, cluster = raftStore, raftStore
+	}
+
+	if *importDynamoDB != "" {
+		entries, err := backup.ReadDynamoDBExport(*importDynamoDB)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		archive := &backup.Archive{Version: backup.Version, Tables: []backup.Table{{Name: *importTable, Entries: entries}}}
+		changes, err := backup.Import(context.Background(), s, archive, backup.Merge, *importDryRun)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, change := range changes {
+			fmt.Printf("%s %s/%s\n", change.Action, change.Table, change.ID)
+		}
+		fmt.Printf("%d items read from the DynamoDB export, %d changes\n", len(entries), len(changes))
+
+		// Importing again on every start would bring back locks released
+		// since
+		return
+	}
+
+	var blobStore blob.Store
+	if *s3 {
+		if *s3Dir == "" {
+			blobStore = blob.NewInMemoryStore(*s3Versioning)
+		} else if *s3Versioning {
+			fmt.Println("S3 versioning is only supported for objects kept in memory")
+			return
+		} else {
+			dirStore, err := blob.NewDirStore(*s3Dir)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			blobStore = dirStore
+		}
+	}
 
-	err := api.Serve(*addr, *cert, *key, store)
+	err := api.Serve(*addr, *cert, *key, s, api.Options{
+		OwnerCheck:       *ownerCheck,
+		HTTPLockTable:    *httpLockTable,
+		HTTPStateTable:   *httpStateTable,
+		BlobStore:        blobStore,
+		AdminToken:       *adminToken,
+		LockQueueTTL:     *lockQueueTTL,
+		FreezeWindows:    freezeWindows,
+		Webhooks:         webhooks,
+		EventsHeldAfter:  *eventsHeldAfter,
+		Cluster:          cluster,
+		ClusterToken:     *raftToken,
+		Replication:      replication,
+		ReplicationToken: *replicationToken,
+	})
 	if err != nil {
 		fmt.Println(err)
 	}
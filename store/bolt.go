@@ -0,0 +1,376 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// boltTables holds a bucket per table, each holding the entries by id
+	boltTables = []byte("tables")
+	// boltFencing holds a bucket per table, each holding the last fencing
+	// token of the locks by id, deleted or not
+	boltFencing = []byte("fencing")
+)
+
+// BoltStore is a Store kept in a single bbolt file. Every call is a bbolt
+// transaction, committed to disk before it returns, so that the file is
+// always consistent after a crash.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// boltEntry is an entry as stored, encoded as JSON.
+type boltEntry struct {
+	Attributes   map[string]string `json:"Attributes"`
+	Owner        string            `json:"Owner,omitempty"`
+	FencingToken uint64            `json:"FencingToken,omitempty"`
+	Created      time.Time         `json:"Created"`
+	Updated      time.Time         `json:"Updated"`
+}
+
+// NewBoltStore opens the bbolt file at path, creating it if needed. It waits
+// up to a second for another process holding the file to release it.
+func NewBoltStore(path string) (*BoltStore, error) {
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltTables, boltFencing} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) Get(ctx context.Context, table, id string) (*Entry, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var entry *Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltTables).Bucket([]byte(table)) == nil {
+			return tableNotFound(table)
+		}
+
+		var err error
+		entry, err = boltGet(tx, table, id)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return entryNotFound(table, id)
+		}
+
+		return nil
+	})
+
+	return entry, err
+}
+
+func (s *BoltStore) Put(ctx context.Context, table string, item Item, condition Condition) (*Entry, *Entry, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var old, current *Entry
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		old, err = boltGet(tx, table, item.ID)
+		if err != nil {
+			return err
+		}
+		if condition != nil && !condition.Holds(old) {
+			current = old
+			return conditionFailed(table, item.ID)
+		}
+
+		err = boltPut(tx, table, item.ID, item.Attributes, item.Owner, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		current, err = boltGet(tx, table, item.ID)
+
+		return err
+	})
+
+	return old, current, err
+}
+
+func (s *BoltStore) Delete(ctx context.Context, table, id string, condition Condition) (*Entry, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var old *Entry
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		entry, err := boltGet(tx, table, id)
+		if err != nil {
+			return err
+		}
+		if condition != nil && !condition.Holds(entry) {
+			old = entry
+			return conditionFailed(table, id)
+		}
+
+		bucket := tx.Bucket(boltTables).Bucket([]byte(table))
+		if bucket == nil {
+			return tableNotFound(table)
+		}
+		if entry == nil {
+			return entryNotFound(table, id)
+		}
+		old = entry
+
+		return bucket.Delete([]byte(id))
+	})
+
+	return old, err
+}
+
+func (s *BoltStore) Scan(ctx context.Context, table, exclusiveStartID string, limit int) ([]Entry, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var result []Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltTables).Bucket([]byte(table))
+		if bucket == nil {
+			return tableNotFound(table)
+		}
+
+		result = []Entry{}
+		cursor := bucket.Cursor()
+		k, _ := cursor.Seek([]byte(exclusiveStartID))
+		if k != nil && string(k) == exclusiveStartID {
+			k, _ = cursor.Next()
+		}
+		for ; k != nil; k, _ = cursor.Next() {
+			if limit > 0 && len(result) == limit {
+				break
+			}
+
+			entry, err := boltGet(tx, table, string(k))
+			if err != nil {
+				return err
+			}
+			result = append(result, *entry)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+func (s *BoltStore) TransactWrite(ctx context.Context, ops []WriteOp) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		reasons := make([]error, len(ops))
+		items := make([]*Entry, len(ops))
+		var canceled bool
+		for i, op := range ops {
+			if op.Condition == nil {
+				continue
+			}
+
+			entry, err := boltGet(tx, op.Table, op.ID)
+			if err != nil {
+				return err
+			}
+			if !op.Condition.Holds(entry) {
+				reasons[i] = ErrConditionalCheckFailed
+				items[i] = entry
+				canceled = true
+			}
+		}
+
+		if canceled {
+			return &TransactionCanceledError{Reasons: reasons, Items: items}
+		}
+
+		for _, op := range ops {
+			var err error
+			switch op.Kind {
+			case WritePut:
+				err = boltPut(tx, op.Table, op.ID, op.Attributes, op.Owner, now)
+			case WriteUpdate:
+				var entry *Entry
+				entry, err = boltGet(tx, op.Table, op.ID)
+				if err != nil {
+					return err
+				}
+				attributes := map[string]string{}
+				owner := op.Owner
+				if entry != nil {
+					attributes = entry.Attributes
+					owner = entry.Owner
+				}
+				for k, v := range op.Attributes {
+					attributes[k] = v
+				}
+				for _, k := range op.Remove {
+					delete(attributes, k)
+				}
+				err = boltPut(tx, op.Table, op.ID, attributes, owner, now)
+			case WriteDelete:
+				if bucket := tx.Bucket(boltTables).Bucket([]byte(op.Table)); bucket != nil {
+					err = bucket.Delete([]byte(op.ID))
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *BoltStore) TransactGet(ctx context.Context, keys []Key) ([]*Entry, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]*Entry, len(keys))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for i, key := range keys {
+			entry, err := boltGet(tx, key.Table, key.ID)
+			if err != nil {
+				return err
+			}
+			result[i] = entry
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+func (s *BoltStore) Tables(ctx context.Context) ([]string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tables := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltTables).ForEach(func(k, _ []byte) error {
+			tables = append(tables, string(k))
+			return nil
+		})
+	})
+
+	return tables, err
+}
+
+// boltGet returns an entry, or nil if it does not exist.
+func boltGet(tx *bolt.Tx, table, id string) (*Entry, error) {
+
+	bucket := tx.Bucket(boltTables).Bucket([]byte(table))
+	if bucket == nil {
+		return nil, nil
+	}
+	value := bucket.Get([]byte(id))
+	if value == nil {
+		return nil, nil
+	}
+
+	var stored boltEntry
+	err := json.Unmarshal(value, &stored)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := make(map[string]string, len(stored.Attributes)+1)
+	for k, v := range stored.Attributes {
+		attributes[k] = v
+	}
+	if stored.FencingToken != 0 {
+		attributes[FencingTokenAttribute] = strconv.FormatUint(stored.FencingToken, 10)
+	}
+
+	return &Entry{ID: id, Kind: Kind(id), Attributes: attributes, Owner: stored.Owner, Created: stored.Created, Updated: stored.Updated}, nil
+}
+
+// boltPut replaces an entry written at now, creating its table if needed. A
+// lock keeps its fencing token and gets a new one when created.
+func boltPut(tx *bolt.Tx, table, id string, attributes map[string]string, owner string, now time.Time) error {
+
+	bucket, err := tx.Bucket(boltTables).CreateBucketIfNotExists([]byte(table))
+	if err != nil {
+		return err
+	}
+
+	stored := boltEntry{Attributes: map[string]string{}, Owner: owner, Created: now, Updated: now}
+	for k, v := range attributes {
+		if k != FencingTokenAttribute {
+			stored.Attributes[k] = v
+		}
+	}
+
+	if value := bucket.Get([]byte(id)); value != nil {
+		var existing boltEntry
+		err = json.Unmarshal(value, &existing)
+		if err != nil {
+			return err
+		}
+		stored.FencingToken, stored.Created = existing.FencingToken, existing.Created
+	} else if Kind(id) == KindLock {
+		fencing, err := tx.Bucket(boltFencing).CreateBucketIfNotExists([]byte(table))
+		if err != nil {
+			return err
+		}
+		if last := fencing.Get([]byte(id)); last != nil {
+			stored.FencingToken = binary.BigEndian.Uint64(last)
+		}
+		stored.FencingToken++
+
+		token := make([]byte, 8)
+		binary.BigEndian.PutUint64(token, stored.FencingToken)
+		err = fencing.Put([]byte(id), token)
+		if err != nil {
+			return err
+		}
+	}
+
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(id), value)
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBoltStoreReopen(t *testing.T) {
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "locker.db")
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	_, _, err = s.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}, Owner: "pablo"}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, err = s.Delete(ctx, "terraform-lock-table", "tfstates/network", nil)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, digest, err := s.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network-md5", Attributes: map[string]string{"Digest": "Test"}}, nil)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	s.Close()
+
+	// Entries, with their times, and fencing counters outlive the process
+	s, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	defer s.Close()
+
+	entries, err := s.Scan(ctx, "terraform-lock-table", "", 0)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	expected := []Entry{{ID: "tfstates/network-md5", Kind: KindDigest, Attributes: map[string]string{"Digest": "Test"}, Created: digest.Created, Updated: digest.Updated}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %v, got %v", expected, entries)
+	}
+
+	_, current, err := s.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test2"}}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if current.Attributes[FencingTokenAttribute] != "2" {
+		t.Errorf("Expected %q, got %q", "2", current.Attributes[FencingTokenAttribute])
+	}
+}
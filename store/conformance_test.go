@@ -0,0 +1,70 @@
+package store_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/pablo-ruth/terraform-state-locker/store"
+	"github.com/pablo-ruth/terraform-state-locker/store/storetest"
+)
+
+func TestInMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store { return store.NewInMemoryStore() })
+}
+
+func TestBoltStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := store.NewBoltStore(filepath.Join(t.TempDir(), "locker.db"))
+		if err != nil {
+			t.Fatalf("NewBoltStore: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := store.NewSQLStore("sqlite", filepath.Join(t.TempDir(), "locker.sqlite"))
+		if err != nil {
+			t.Fatalf("NewSQLStore: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestRedisStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		server := miniredis.RunT(t)
+		s := store.NewRedisStore(redis.NewClient(&redis.Options{Addr: server.Addr()}), "locker:", "")
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestRaftStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := store.NewRaftStore(store.RaftConfig{ID: "a", RaftAddr: "127.0.0.1:0", RPCAddr: "127.0.0.1:0", Bootstrap: true, Token: "secret"})
+		if err != nil {
+			t.Fatalf("NewRaftStore: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestReplicatedStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s := store.NewPrimary()
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestPublisherConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store { return store.NewPublisher(store.NewInMemoryStore(), 100) })
+}
@@ -0,0 +1,361 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ChangeType string
+
+const (
+	// ChangeAcquire is the creation of an entry.
+	ChangeAcquire ChangeType = "acquire"
+	// ChangeUpdate is the replacement of an existing entry.
+	ChangeUpdate ChangeType = "update"
+	// ChangeRelease is the deletion of an entry.
+	ChangeRelease ChangeType = "release"
+	// ChangeExpiry is published by the owner of the Publisher, not by the
+	// store, when a lock has been held for too long.
+	ChangeExpiry ChangeType = "expiry"
+)
+
+// Change is a change made to an entry. Attributes are the attributes after a
+// put and before a delete, Old the attributes before the change, nil for an
+// acquire.
+type Change struct {
+	Seq        uint64
+	Type       ChangeType
+	Table      string
+	ID         string
+	Kind       EntryKind
+	Attributes map[string]string
+	Old        map[string]string
+	Time       time.Time
+}
+
+const (
+	// StreamRetention is how long the stream records of a table are kept,
+	// like DynamoDB Streams.
+	StreamRetention = 24 * time.Hour
+	// maxStreamRecords bounds the stream records kept per table.
+	maxStreamRecords = 10000
+)
+
+// subscriberBuffer is the number of changes a subscriber may lag behind
+// before it is dropped.
+const subscriberBuffer = 256
+
+// Publisher is a Store publishing every change made through it to its
+// subscribers, the changes of an entry in the order they are made. It keeps
+// the last changes so that subscribers can resume after a disconnection.
+type Publisher struct {
+	Store
+
+	mu          sync.Mutex
+	locks       map[Key]*keyLock
+	seq         uint64
+	history     []Change
+	size        int
+	subscribers map[*Subscription]bool
+	streams     map[string]*streamLog
+	created     time.Time
+}
+
+// streamLog holds the item-level changes of a table, trimmed being the
+// sequence number of the last record dropped.
+type streamLog struct {
+	records []Change
+	trimmed uint64
+}
+
+// keyLock serializes the writes to an entry so that its changes are
+// published in the order they are made, refs counting the writes holding or
+// waiting for it.
+type keyLock struct {
+	sync.Mutex
+	key  Key
+	refs int
+}
+
+// Subscription receives changes on C, which is closed when the subscriber
+// lags behind too much or unsubscribes.
+type Subscription struct {
+	C <-chan Change
+	c chan Change
+}
+
+// NewPublisher publishes the changes made to s, keeping the last history
+// ones.
+func NewPublisher(s Store, history int) *Publisher {
+	return &Publisher{
+		Store:       s,
+		locks:       make(map[Key]*keyLock),
+		size:        history,
+		subscribers: make(map[*Subscription]bool),
+		streams:     make(map[string]*streamLog),
+		created:     time.Now().UTC(),
+	}
+}
+
+// Created returns when the publisher started recording changes.
+func (p *Publisher) Created() time.Time {
+	return p.created
+}
+
+// lock serializes the writes to keys with the other writes to any of them,
+// writes to other entries running concurrently. It returns the function
+// unlocking them.
+func (p *Publisher) lock(keys ...Key) func() {
+
+	// Keys are locked in the same order by every write to avoid deadlocks
+	sorted := append([]Key(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Table != sorted[j].Table {
+			return sorted[i].Table < sorted[j].Table
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	p.mu.Lock()
+	var locks []*keyLock
+	for i, key := range sorted {
+		if i > 0 && key == sorted[i-1] {
+			continue
+		}
+		l, ok := p.locks[key]
+		if !ok {
+			l = &keyLock{key: key}
+			p.locks[key] = l
+		}
+		l.refs++
+		locks = append(locks, l)
+	}
+	p.mu.Unlock()
+
+	for _, l := range locks {
+		l.Lock()
+	}
+
+	return func() {
+		for _, l := range locks {
+			l.Unlock()
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, l := range locks {
+			l.refs--
+			if l.refs == 0 {
+				delete(p.locks, l.key)
+			}
+		}
+	}
+}
+
+func (p *Publisher) Put(ctx context.Context, table string, item Item, condition Condition) (*Entry, *Entry, error) {
+	defer p.lock(Key{Table: table, ID: item.ID})()
+
+	old, current, err := p.Store.Put(ctx, table, item, condition)
+	if err != nil {
+		return old, current, err
+	}
+
+	changeType := ChangeUpdate
+	if old == nil {
+		changeType = ChangeAcquire
+	}
+	p.Publish(Change{Type: changeType, Table: table, ID: item.ID, Attributes: current.GetAttributes(), Old: old.GetAttributes()})
+
+	return old, current, nil
+}
+
+func (p *Publisher) Delete(ctx context.Context, table, id string, condition Condition) (*Entry, error) {
+	defer p.lock(Key{Table: table, ID: id})()
+
+	old, err := p.Store.Delete(ctx, table, id, condition)
+	if err != nil {
+		return old, err
+	}
+	p.Publish(Change{Type: ChangeRelease, Table: table, ID: id, Attributes: old.Attributes, Old: old.Attributes})
+
+	return old, nil
+}
+
+func (p *Publisher) TransactWrite(ctx context.Context, ops []WriteOp) error {
+
+	keys := make([]Key, len(ops))
+	for i, op := range ops {
+		keys[i] = Key{Table: op.Table, ID: op.ID}
+	}
+
+	// The writes to these entries are serialized, so the entries read around
+	// the transaction tell what it changed
+	defer p.lock(keys...)()
+
+	before, err := p.Store.TransactGet(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	err = p.Store.TransactWrite(ctx, ops)
+	if err != nil {
+		return err
+	}
+
+	// The transaction is committed, its changes are published even if ctx
+	// is done by now
+	after, err := p.Store.TransactGet(context.Background(), keys)
+	if err != nil {
+		return err
+	}
+
+	for i, op := range ops {
+		switch {
+		case op.Kind == WriteConditionCheck:
+		case before[i] == nil && after[i] != nil:
+			p.Publish(Change{Type: ChangeAcquire, Table: op.Table, ID: op.ID, Attributes: after[i].Attributes})
+		case before[i] != nil && after[i] == nil:
+			p.Publish(Change{Type: ChangeRelease, Table: op.Table, ID: op.ID, Attributes: before[i].Attributes, Old: before[i].Attributes})
+		case after[i] != nil:
+			p.Publish(Change{Type: ChangeUpdate, Table: op.Table, ID: op.ID, Attributes: after[i].Attributes, Old: before[i].GetAttributes()})
+		}
+	}
+
+	return nil
+}
+
+// Publish assigns change the next sequence number and sends it to the
+// subscribers. It never blocks: a subscriber whose buffer is full is
+// dropped and has to resume from the history.
+func (p *Publisher) Publish(change Change) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.seq++
+	change.Seq = p.seq
+	change.Kind = Kind(change.ID)
+	if change.Time.IsZero() {
+		change.Time = time.Now().UTC()
+	}
+
+	p.history = append(p.history, change)
+	if len(p.history) > p.size {
+		p.history = append(p.history[:0:0], p.history[len(p.history)-p.size:]...)
+	}
+
+	if change.Type != ChangeExpiry {
+		p.record(change)
+	}
+
+	for sub := range p.subscribers {
+		select {
+		case sub.c <- change:
+		default:
+			delete(p.subscribers, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// record appends change to the stream log of its table and drops the
+// records past retention. The caller must hold p.mu.
+func (p *Publisher) record(change Change) {
+
+	log, ok := p.streams[change.Table]
+	if !ok {
+		log = &streamLog{}
+		p.streams[change.Table] = log
+	}
+	log.records = append(log.records, change)
+
+	var drop int
+	for drop < len(log.records) && (len(log.records)-drop > maxStreamRecords || change.Time.Sub(log.records[drop].Time) > StreamRetention) {
+		drop++
+	}
+	if drop > 0 {
+		log.trimmed = log.records[drop-1].Seq
+		log.records = append(log.records[:0:0], log.records[drop:]...)
+	}
+}
+
+// StreamRecords returns up to limit item-level changes of table after
+// afterSeq, in order. It reports whether records after afterSeq were already
+// dropped.
+func (p *Publisher) StreamRecords(table string, afterSeq uint64, limit int) ([]Change, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	log, ok := p.streams[table]
+	if !ok {
+		return nil, false
+	}
+	if afterSeq < log.trimmed {
+		return nil, true
+	}
+
+	var result []Change
+	for _, change := range log.records {
+		if change.Seq <= afterSeq {
+			continue
+		}
+		if len(result) == limit {
+			break
+		}
+		result = append(result, change)
+	}
+
+	return result, false
+}
+
+// StreamStart returns the sequence number of the oldest record kept for
+// table, or of the next one when none is.
+func (p *Publisher) StreamStart(table string) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if log, ok := p.streams[table]; ok && len(log.records) > 0 {
+		return log.records[0].Seq
+	}
+
+	return p.seq + 1
+}
+
+// LastSeq returns the sequence number of the last change published.
+func (p *Publisher) LastSeq() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.seq
+}
+
+// Subscribe returns the changes retained after lastSeq along with a
+// subscription receiving the next ones.
+func (p *Publisher) Subscribe(lastSeq uint64) ([]Change, *Subscription) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var missed []Change
+	for _, change := range p.history {
+		if change.Seq > lastSeq {
+			missed = append(missed, change)
+		}
+	}
+
+	c := make(chan Change, subscriberBuffer)
+	sub := &Subscription{C: c, c: c}
+	p.subscribers[sub] = true
+
+	return missed, sub
+}
+
+func (p *Publisher) Unsubscribe(sub *Subscription) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.subscribers[sub] {
+		delete(p.subscribers, sub)
+		close(sub.c)
+	}
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPublisher(t *testing.T) {
+
+	ctx := context.Background()
+	cases := []struct {
+		name     string
+		apply    func(p *Publisher) error
+		expected []ChangeType
+	}{
+		{
+			name: "put and delete",
+			apply: func(p *Publisher) error {
+				if _, _, err := p.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}, NotExists); err != nil {
+					return err
+				}
+				if _, _, err := p.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test2"}}, nil); err != nil {
+					return err
+				}
+				_, err := p.Delete(ctx, "terraform-lock-table", "tfstates/network", nil)
+				return err
+			},
+			expected: []ChangeType{ChangeAcquire, ChangeUpdate, ChangeRelease},
+		},
+		{
+			name: "failed writes are not published",
+			apply: func(p *Publisher) error {
+				if _, _, err := p.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}, NotExists); err != nil {
+					return err
+				}
+				p.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test2"}}, NotExists)
+				p.Delete(ctx, "terraform-lock-table", "tfstates/dns", nil)
+				return nil
+			},
+			expected: []ChangeType{ChangeAcquire},
+		},
+		{
+			name: "transaction",
+			apply: func(p *Publisher) error {
+				if _, _, err := p.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}, NotExists); err != nil {
+					return err
+				}
+				return p.TransactWrite(ctx, []WriteOp{
+					{Kind: WriteDelete, Table: "terraform-lock-table", ID: "tfstates/network"},
+					{Kind: WritePut, Table: "terraform-lock-table", ID: "tfstates/dns", Attributes: map[string]string{"Info": "Test"}},
+					{Kind: WriteConditionCheck, Table: "terraform-lock-table", ID: "tfstates/app"},
+				})
+			},
+			expected: []ChangeType{ChangeAcquire, ChangeRelease, ChangeAcquire},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewPublisher(NewInMemoryStore(), 10)
+			_, sub := p.Subscribe(0)
+
+			err := c.apply(p)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			p.Unsubscribe(sub)
+
+			var changes []ChangeType
+			for change := range sub.C {
+				changes = append(changes, change.Type)
+			}
+
+			if !reflect.DeepEqual(changes, c.expected) {
+				t.Errorf("Expected %v, got %v", c.expected, changes)
+			}
+		})
+	}
+}
+
+func TestPublisherResume(t *testing.T) {
+
+	p := NewPublisher(NewInMemoryStore(), 2)
+	_, slow := p.Subscribe(0)
+
+	for i := 0; i < subscriberBuffer+1; i++ {
+		p.Publish(Change{Type: ChangeExpiry, Table: "terraform-lock-table", ID: "tfstates/network"})
+	}
+
+	// The slow subscriber is dropped instead of blocking the publisher
+	var received int
+	for range slow.C {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("Expected %d changes, got %d", subscriberBuffer, received)
+	}
+
+	// Resuming replays the changes still retained after the last one seen
+	missed, sub := p.Subscribe(subscriberBuffer - 1)
+	defer p.Unsubscribe(sub)
+
+	var seqs []uint64
+	for _, change := range missed {
+		seqs = append(seqs, change.Seq)
+	}
+	expected := []uint64{subscriberBuffer, subscriberBuffer + 1}
+	if !reflect.DeepEqual(seqs, expected) {
+		t.Errorf("Expected %v, got %v", expected, seqs)
+	}
+}
+
+// blockingStore blocks the puts of the entry blocked until release is closed.
+type blockingStore struct {
+	Store
+	blocked string
+	release chan struct{}
+}
+
+func (s *blockingStore) Put(ctx context.Context, table string, item Item, condition Condition) (*Entry, *Entry, error) {
+	if item.ID == s.blocked {
+		<-s.release
+	}
+	return s.Store.Put(ctx, table, item, condition)
+}
+
+func TestPublisherConcurrentWrites(t *testing.T) {
+
+	ctx := context.Background()
+	s := &blockingStore{Store: NewInMemoryStore(), blocked: "tfstates/network", release: make(chan struct{})}
+	p := NewPublisher(s, 10)
+
+	blocked := make(chan error)
+	go func() {
+		_, _, err := p.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}, NotExists)
+		blocked <- err
+	}()
+
+	// A slow write does not delay the writes to other entries
+	done := make(chan error)
+	go func() {
+		_, _, err := p.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/dns", Attributes: map[string]string{"Info": "Test"}}, NotExists)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the write")
+	}
+
+	close(s.release)
+	if err := <-blocked; err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,811 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// errStale is returned when the entries a write was decided on changed
+// before it was committed, the write is then decided again.
+var errStale = fmt.Errorf("stale write")
+
+const (
+	raftTimeout = 10 * time.Second
+	// raftRetries bounds the attempts of a write that keeps going stale or
+	// finds no leader.
+	raftRetries = 50
+)
+
+// RaftConfig configures a node of a RaftStore cluster.
+type RaftConfig struct {
+	// ID identifies the node in the cluster.
+	ID string
+	// RaftAddr is the address of the Raft transport.
+	RaftAddr string
+	// RPCAddr is the HTTP address other nodes forward writes and join
+	// requests to.
+	RPCAddr string
+	// Dir holds the Raft log and snapshots, kept in memory when empty.
+	Dir string
+	// Bootstrap starts a new cluster made of this node alone.
+	Bootstrap bool
+	// Join is the RPCAddr of a member of the cluster to join.
+	Join string
+	// APIAddr is the base URL of the API served by the node, for followers
+	// to forward requests to the leader.
+	APIAddr string
+	// Token authenticates the nodes to each other, both on the Raft
+	// transport and as the bearer token of the RPCs. Every node of the
+	// cluster must be given the same.
+	Token string
+}
+
+// RaftStore is a Store replicated with Raft. Writes are committed through
+// the Raft log before they are acknowledged, followers forwarding them to
+// the leader. Reads are served by the local replica, which may lag behind
+// the leader on followers.
+//
+// Conditions cannot be sent over the wire, so a write is decided on the
+// local replica and committed along with the entries it was decided on. It
+// is applied only if they did not change in the meantime, and decided again
+// otherwise.
+type RaftStore struct {
+	config RaftConfig
+	raft   *raft.Raft
+	fsm    *raftFSM
+	rpc    *http.Server
+	client *http.Client
+}
+
+// raftFSM applies the Raft log to an InMemoryStore. It also replicates the
+// addresses of the nodes so that followers can reach the leader.
+type raftFSM struct {
+	state *InMemoryStore
+
+	mu    sync.Mutex
+	nodes map[string]raftNode
+}
+
+// raftCommand is an entry of the Raft log: a transaction whose ops carry
+// the entries they were decided on, or the registration of a node. Time is
+// when the transaction was decided, so that every node records the same.
+type raftCommand struct {
+	Ops  []raftWriteOp `json:"Ops,omitempty"`
+	Time time.Time     `json:"Time"`
+	Node *raftNode     `json:"Node,omitempty"`
+}
+
+type raftWriteOp struct {
+	Kind       WriteOpKind       `json:"Kind"`
+	Table      string            `json:"Table"`
+	ID         string            `json:"ID"`
+	Attributes map[string]string `json:"Attributes,omitempty"`
+	Remove     []string          `json:"Remove,omitempty"`
+	Owner      string            `json:"Owner,omitempty"`
+	Expected   *raftEntry        `json:"Expected"`
+}
+
+// raftEntry is an entry as seen by a write, the attributes and owner
+// decisions can depend on.
+type raftEntry struct {
+	Attributes map[string]string `json:"Attributes"`
+	Owner      string            `json:"Owner"`
+}
+
+type raftNode struct {
+	ID       string `json:"ID"`
+	RaftAddr string `json:"RaftAddr"`
+	RPCAddr  string `json:"RPCAddr"`
+	APIAddr  string `json:"APIAddr,omitempty"`
+}
+
+// raftResult is the outcome of a command, Current holding each op's entry
+// once applied.
+type raftResult struct {
+	Stale   bool     `json:"Stale"`
+	Current []*Entry `json:"Current"`
+}
+
+// NewRaftStore starts a node, bootstrapping or joining a cluster as
+// configured.
+func NewRaftStore(config RaftConfig) (*RaftStore, error) {
+
+	if config.Token == "" {
+		return nil, fmt.Errorf("raft token is missing")
+	}
+
+	s := &RaftStore{
+		config: config,
+		fsm:    &raftFSM{state: NewInMemoryStore(), nodes: make(map[string]raftNode)},
+		client: &http.Client{Timeout: raftTimeout},
+	}
+
+	listener, err := net.Listen("tcp", config.RPCAddr)
+	if err != nil {
+		return nil, err
+	}
+	s.config.RPCAddr = listener.Addr().String()
+
+	stream, err := newRaftStreamLayer(config.RaftAddr, config.Token)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	transport := raft.NewNetworkTransport(stream, 3, raftTimeout, io.Discard)
+	s.config.RaftAddr = string(transport.LocalAddr())
+
+	var logs raft.LogStore
+	var stable raft.StableStore
+	var snapshots raft.SnapshotStore
+	if config.Dir == "" {
+		inmem := raft.NewInmemStore()
+		logs, stable, snapshots = inmem, inmem, raft.NewInmemSnapshotStore()
+	} else {
+		err = os.MkdirAll(config.Dir, 0700)
+		if err != nil {
+			listener.Close()
+			transport.Close()
+			return nil, err
+		}
+
+		boltStore, err := raftboltdb.NewBoltStore(filepath.Join(config.Dir, "raft.db"))
+		if err != nil {
+			listener.Close()
+			transport.Close()
+			return nil, err
+		}
+		logs, stable = boltStore, boltStore
+
+		snapshots, err = raft.NewFileSnapshotStore(config.Dir, 2, io.Discard)
+		if err != nil {
+			listener.Close()
+			transport.Close()
+			return nil, err
+		}
+	}
+
+	raftConfig := raft.DefaultConfig()
+	raftConfig.LocalID = raft.ServerID(config.ID)
+	raftConfig.LogLevel = "WARN"
+
+	s.raft, err = raft.NewRaft(raftConfig, s.fsm, logs, stable, snapshots, transport)
+	if err != nil {
+		listener.Close()
+		transport.Close()
+		return nil, err
+	}
+
+	r := chi.NewRouter()
+	r.Use(s.authenticate)
+	r.Post("/raft/apply", s.handleApply)
+	r.Post("/raft/join", s.handleJoin)
+	r.Post("/raft/leave", s.handleLeave)
+	s.rpc = &http.Server{Handler: r}
+	go s.rpc.Serve(listener)
+
+	self := raftNode{ID: config.ID, RaftAddr: s.config.RaftAddr, RPCAddr: s.config.RPCAddr, APIAddr: config.APIAddr}
+	if config.Bootstrap {
+		err = s.raft.BootstrapCluster(raft.Configuration{
+			Servers: []raft.Server{{ID: raft.ServerID(config.ID), Address: raft.ServerAddress(s.config.RaftAddr)}},
+		}).Error()
+		if err != nil && err != raft.ErrCantBootstrap {
+			s.Close()
+			return nil, err
+		}
+		// Only the leader knows its own RPC address until it is registered
+		for deadline := time.Now().Add(raftTimeout); !s.IsLeader() && time.Now().Before(deadline); {
+			time.Sleep(50 * time.Millisecond)
+		}
+		_, err = s.apply(context.Background(), raftCommand{Node: &self})
+	} else if config.Join != "" {
+		err = s.post(context.Background(), config.Join, "/raft/join", self, nil)
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// RPCAddr returns the address the node serves RPCs on.
+func (s *RaftStore) RPCAddr() string {
+	return s.config.RPCAddr
+}
+
+// IsLeader reports whether the node is the leader of the cluster.
+func (s *RaftStore) IsLeader() bool {
+	return s.raft.State() == raft.Leader
+}
+
+// LeaderAPI returns the APIAddr of the leader, empty when it is not known.
+func (s *RaftStore) LeaderAPI() string {
+	_, leaderID := s.raft.LeaderWithID()
+	return s.fsm.node(string(leaderID)).APIAddr
+}
+
+// Join adds a voting node to the cluster.
+func (s *RaftStore) Join(id, raftAddr, rpcAddr, apiAddr string) error {
+	return s.post(context.Background(), "", "/raft/join", raftNode{ID: id, RaftAddr: raftAddr, RPCAddr: rpcAddr, APIAddr: apiAddr}, nil)
+}
+
+// Leave removes a node from the cluster.
+func (s *RaftStore) Leave(id string) error {
+	return s.post(context.Background(), "", "/raft/leave", raftNode{ID: id}, nil)
+}
+
+// Close stops the node without leaving the cluster.
+func (s *RaftStore) Close() error {
+	s.rpc.Close()
+	return s.raft.Shutdown().Error()
+}
+
+func (s *RaftStore) Get(ctx context.Context, table, id string) (*Entry, error) {
+	return s.fsm.state.Get(ctx, table, id)
+}
+
+func (s *RaftStore) Scan(ctx context.Context, table, exclusiveStartID string, limit int) ([]Entry, error) {
+	return s.fsm.state.Scan(ctx, table, exclusiveStartID, limit)
+}
+
+func (s *RaftStore) TransactGet(ctx context.Context, keys []Key) ([]*Entry, error) {
+	return s.fsm.state.TransactGet(ctx, keys)
+}
+
+func (s *RaftStore) Tables(ctx context.Context) ([]string, error) {
+	return s.fsm.state.Tables(ctx)
+}
+
+func (s *RaftStore) Put(ctx context.Context, table string, item Item, condition Condition) (*Entry, *Entry, error) {
+
+	var old *Entry
+	result, err := s.write(ctx, func() ([]raftWriteOp, error) {
+		old = s.fsm.state.entryLocked(table, item.ID)
+		if condition != nil && !condition.Holds(old) {
+			return nil, conditionFailed(table, item.ID)
+		}
+
+		return []raftWriteOp{{Kind: WritePut, Table: table, ID: item.ID, Attributes: item.Attributes, Owner: item.Owner, Expected: raftExpected(old)}}, nil
+	})
+	if errors.Is(err, ErrConditionalCheckFailed) {
+		return old, old, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return old, result.Current[0], nil
+}
+
+func (s *RaftStore) Delete(ctx context.Context, table, id string, condition Condition) (*Entry, error) {
+
+	var old *Entry
+	_, err := s.write(ctx, func() ([]raftWriteOp, error) {
+		old = s.fsm.state.entryLocked(table, id)
+		if condition != nil && !condition.Holds(old) {
+			return nil, conditionFailed(table, id)
+		}
+		if old == nil {
+			if _, err := s.fsm.state.Scan(ctx, table, "", 1); errors.Is(err, ErrTableNotFound) {
+				return nil, err
+			}
+			return nil, entryNotFound(table, id)
+		}
+
+		return []raftWriteOp{{Kind: WriteDelete, Table: table, ID: id, Expected: raftExpected(old)}}, nil
+	})
+	if err != nil {
+		return old, err
+	}
+
+	return old, nil
+}
+
+func (s *RaftStore) TransactWrite(ctx context.Context, ops []WriteOp) error {
+
+	_, err := s.write(ctx, func() ([]raftWriteOp, error) {
+		reasons := make([]error, len(ops))
+		items := make([]*Entry, len(ops))
+		var canceled bool
+
+		raftOps := make([]raftWriteOp, len(ops))
+		for i, op := range ops {
+			entry := s.fsm.state.entryLocked(op.Table, op.ID)
+			if op.Condition != nil && !op.Condition.Holds(entry) {
+				reasons[i] = ErrConditionalCheckFailed
+				items[i] = entry
+				canceled = true
+			}
+
+			raftOps[i] = raftWriteOp{
+				Kind:       op.Kind,
+				Table:      op.Table,
+				ID:         op.ID,
+				Attributes: op.Attributes,
+				Remove:     op.Remove,
+				Owner:      op.Owner,
+				Expected:   raftExpected(entry),
+			}
+		}
+
+		if canceled {
+			return nil, &TransactionCanceledError{Reasons: reasons, Items: items}
+		}
+
+		return raftOps, nil
+	})
+
+	return err
+}
+
+// raftExpected returns an entry of the local replica as a write expects to find
+// it when applied.
+func raftExpected(entry *Entry) *raftEntry {
+
+	if entry == nil {
+		return nil
+	}
+
+	return &raftEntry{Attributes: entry.Attributes, Owner: entry.Owner}
+}
+
+// write decides a write with decide and commits it, deciding it again as
+// long as it goes stale.
+func (s *RaftStore) write(ctx context.Context, decide func() ([]raftWriteOp, error)) (raftResult, error) {
+
+	for i := 0; i < raftRetries; i++ {
+		if err := ctx.Err(); err != nil {
+			return raftResult{}, err
+		}
+
+		ops, err := decide()
+		if err != nil {
+			return raftResult{}, err
+		}
+
+		result, err := s.apply(ctx, raftCommand{Ops: ops, Time: time.Now().UTC()})
+		if err == errStale || err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+			// Let the local replica catch up or the cluster elect a
+			// leader
+			if err := backoff(ctx); err != nil {
+				return raftResult{}, err
+			}
+			continue
+		}
+
+		return result, err
+	}
+
+	return raftResult{}, fmt.Errorf("write not committed after %d attempts", raftRetries)
+}
+
+// apply commits a command through the leader, forwarding it when this node
+// is a follower. Once handed to Raft, a command is committed or not
+// regardless of ctx.
+func (s *RaftStore) apply(ctx context.Context, command raftCommand) (raftResult, error) {
+
+	if !s.IsLeader() {
+		var result raftResult
+		err := s.post(ctx, "", "/raft/apply", command, &result)
+		if err == nil && result.Stale {
+			err = errStale
+		}
+		return result, err
+	}
+
+	data, err := json.Marshal(command)
+	if err != nil {
+		return raftResult{}, err
+	}
+
+	future := s.raft.Apply(data, raftTimeout)
+	err = future.Error()
+	if err != nil {
+		return raftResult{}, err
+	}
+
+	if err, ok := future.Response().(error); ok {
+		return raftResult{}, err
+	}
+	result := future.Response().(raftResult)
+	if result.Stale {
+		return result, errStale
+	}
+
+	return result, nil
+}
+
+// post sends an RPC to addr, or to the leader when addr is empty, and
+// decodes its response into resp when not nil.
+func (s *RaftStore) post(ctx context.Context, addr, path string, req, resp interface{}) error {
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for i := 0; i < raftRetries; i++ {
+		target := addr
+		if target == "" {
+			_, leaderID := s.raft.LeaderWithID()
+			target = s.fsm.node(string(leaderID)).RPCAddr
+		}
+		if target == "" {
+			lastErr = raft.ErrNotLeader
+			if err := backoff(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+target+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Authorization", "Bearer "+s.config.Token)
+
+		httpResp, err := s.client.Do(httpReq)
+		if err != nil {
+			lastErr = err
+			if err := backoff(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := io.ReadAll(httpResp.Body)
+		httpResp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		// The target may have lost leadership in the meantime
+		if httpResp.StatusCode == http.StatusMisdirectedRequest {
+			lastErr = raft.ErrNotLeader
+			if err := backoff(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		if httpResp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s", path, data)
+		}
+
+		if resp == nil {
+			return nil
+		}
+		return json.Unmarshal(data, resp)
+	}
+
+	return lastErr
+}
+
+// backoff waits before a retry, giving up with the error of ctx once it is
+// done.
+func backoff(ctx context.Context) error {
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(100 * time.Millisecond):
+		return nil
+	}
+}
+
+// authenticate rejects the RPCs that do not carry the token of the cluster,
+// before anything is read from them.
+func (s *RaftStore) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *RaftStore) handleApply(w http.ResponseWriter, r *http.Request) {
+
+	var command raftCommand
+	err := json.NewDecoder(r.Body).Decode(&command)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		return
+	}
+
+	if !s.IsLeader() {
+		w.WriteHeader(http.StatusMisdirectedRequest)
+		w.Write([]byte("Not the leader"))
+		return
+	}
+
+	result, err := s.apply(r.Context(), command)
+	if err != nil && err != errStale {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeRaftResponse(w, result)
+}
+
+func (s *RaftStore) handleJoin(w http.ResponseWriter, r *http.Request) {
+
+	var node raftNode
+	err := json.NewDecoder(r.Body).Decode(&node)
+	if err != nil || node.ID == "" || node.RaftAddr == "" || node.RPCAddr == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		return
+	}
+
+	// Joining through a follower is forwarded to the leader
+	if !s.IsLeader() {
+		err = s.post(r.Context(), "", "/raft/join", node, nil)
+	} else {
+		err = s.raft.AddVoter(raft.ServerID(node.ID), raft.ServerAddress(node.RaftAddr), 0, raftTimeout).Error()
+		if err == nil {
+			_, err = s.apply(r.Context(), raftCommand{Node: &node})
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeRaftResponse(w, struct{}{})
+}
+
+func (s *RaftStore) handleLeave(w http.ResponseWriter, r *http.Request) {
+
+	var node raftNode
+	err := json.NewDecoder(r.Body).Decode(&node)
+	if err != nil || node.ID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		return
+	}
+
+	if !s.IsLeader() {
+		err = s.post(r.Context(), "", "/raft/leave", node, nil)
+	} else {
+		err = s.raft.RemoveServer(raft.ServerID(node.ID), 0, raftTimeout).Error()
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeRaftResponse(w, struct{}{})
+}
+
+func writeRaftResponse(w http.ResponseWriter, resp interface{}) {
+
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal server error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
+
+func (f *raftFSM) node(id string) raftNode {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.nodes[id]
+}
+
+// Apply returns the raftResult of a command, or an error when it cannot be
+// decoded. Such an entry is skipped by every node alike.
+func (f *raftFSM) Apply(entry *raft.Log) interface{} {
+
+	var command raftCommand
+	err := json.Unmarshal(entry.Data, &command)
+	if err != nil {
+		err = fmt.Errorf("decoding raft command %d: %w", entry.Index, err)
+		log.Printf("Skipping raft log entry: %v", err)
+		return err
+	}
+
+	if command.Node != nil {
+		f.mu.Lock()
+		f.nodes[command.Node.ID] = *command.Node
+		f.mu.Unlock()
+		return raftResult{}
+	}
+
+	ops := make([]WriteOp, len(command.Ops))
+	for i, op := range command.Ops {
+		expected := op.Expected
+		ops[i] = WriteOp{
+			Kind:       op.Kind,
+			Table:      op.Table,
+			ID:         op.ID,
+			Attributes: op.Attributes,
+			Remove:     op.Remove,
+			Owner:      op.Owner,
+			Condition: ConditionFunc(func(entry *Entry) bool {
+				if entry == nil || expected == nil {
+					return entry == nil && expected == nil
+				}
+				return entry.Owner == expected.Owner && reflect.DeepEqual(entry.Attributes, expected.Attributes)
+			}),
+		}
+	}
+
+	// Committed commands are applied on every node whatever became of the
+	// caller
+	ctx := context.Background()
+	err = f.state.transactWrite(ops, command.Time)
+	if err != nil {
+		return raftResult{Stale: true}
+	}
+
+	result := raftResult{Current: make([]*Entry, len(ops))}
+	for i, op := range ops {
+		result.Current[i], _ = f.state.Get(ctx, op.Table, op.ID)
+	}
+
+	return result
+}
+
+// raftSnapshot is the state of the FSM at some point of the log.
+type raftSnapshot struct {
+	Store inMemorySnapshot    `json:"Store"`
+	Nodes map[string]raftNode `json:"Nodes"`
+}
+
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	nodes := make(map[string]raftNode, len(f.nodes))
+	for id, node := range f.nodes {
+		nodes[id] = node
+	}
+
+	return &raftSnapshot{Store: f.state.snapshot(), Nodes: nodes}, nil
+}
+
+func (f *raftFSM) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+
+	var restored raftSnapshot
+	err := json.NewDecoder(snapshot).Decode(&restored)
+	if err != nil {
+		return err
+	}
+
+	f.state.restore(restored.Store)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nodes = restored.Nodes
+	if f.nodes == nil {
+		f.nodes = make(map[string]raftNode)
+	}
+
+	return nil
+}
+
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+
+	err := json.NewEncoder(sink).Encode(s)
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+
+	return sink.Close()
+}
+
+func (s *raftSnapshot) Release() {}
+
+// inMemorySnapshot is the content of an InMemoryStore.
+type inMemorySnapshot struct {
+	Tables  map[string]map[string]inMemorySnapshotEntry `json:"Tables"`
+	Fencing []inMemorySnapshotFencing                   `json:"Fencing"`
+}
+
+type inMemorySnapshotEntry struct {
+	Attributes   map[string]string `json:"Attributes"`
+	Owner        string            `json:"Owner,omitempty"`
+	FencingToken uint64            `json:"FencingToken,omitempty"`
+	Created      time.Time         `json:"Created"`
+	Updated      time.Time         `json:"Updated"`
+}
+
+type inMemorySnapshotFencing struct {
+	Table string `json:"Table"`
+	ID    string `json:"ID"`
+	Token uint64 `json:"Token"`
+}
+
+func (s *InMemoryStore) snapshot() inMemorySnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := inMemorySnapshot{Tables: make(map[string]map[string]inMemorySnapshotEntry)}
+	for table, storeTable := range s.tables {
+		entries := make(map[string]inMemorySnapshotEntry, len(storeTable.entries))
+		for id, storeEntry := range storeTable.entries {
+			attributes := make(map[string]string)
+			for _, attribute := range storeEntry.attributes {
+				attributes[attribute.key] = attribute.value
+			}
+			entries[id] = inMemorySnapshotEntry{Attributes: attributes, Owner: storeEntry.owner, FencingToken: storeEntry.fencingToken, Created: storeEntry.created, Updated: storeEntry.updated}
+		}
+		result.Tables[table] = entries
+	}
+
+	for key, token := range s.fencing {
+		result.Fencing = append(result.Fencing, inMemorySnapshotFencing{Table: key.Table, ID: key.ID, Token: token})
+	}
+
+	return result
+}
+
+func (s *InMemoryStore) restore(snapshot inMemorySnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Tables are restored even when empty, so that they are not reported
+	// missing
+	s.tables = make(map[string]InMemoryStoreTable)
+	for table, entries := range snapshot.Tables {
+		s.tables[table] = InMemoryStoreTable{entries: make(map[string]InMemoryStoreEntry)}
+		for id, entry := range entries {
+			s.put(table, id, entry.Attributes, entry.Owner, entry.Updated)
+			storeEntry := s.tables[table].entries[id]
+			storeEntry.fencingToken, storeEntry.created = entry.FencingToken, entry.Created
+			s.tables[table].entries[id] = storeEntry
+		}
+	}
+
+	// put counts the entries as acquisitions, the counters are restored
+	// afterwards
+	s.fencing = make(map[Key]uint64)
+	for _, fencing := range snapshot.Fencing {
+		s.fencing[Key{Table: fencing.Table, ID: fencing.ID}] = fencing.Token
+	}
+}
+
+// entryLocked returns a copy of an entry, or nil if it does not exist.
+func (s *InMemoryStore) entryLocked(table, id string) *Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.entry(table, id)
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// raftHandshakeTimeout bounds the authentication of a Raft connection.
+const raftHandshakeTimeout = 5 * time.Second
+
+// raftStreamLayer is the TCP stream layer of the Raft transport, every
+// connection proving it knows the token of the cluster before it is handed
+// to Raft. The accepting side sends a random challenge that the dialing side
+// answers with its HMAC-SHA256 under the token, so the token itself never
+// goes over the wire.
+type raftStreamLayer struct {
+	listener net.Listener
+	token    string
+
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newRaftStreamLayer listens on addr, which must be a host Raft can
+// advertise to the other nodes.
+func newRaftStreamLayer(addr, token string) (*raftStreamLayer, error) {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && tcpAddr.IP.IsUnspecified() {
+		listener.Close()
+		return nil, fmt.Errorf("raft address %s is not advertisable, listen on a specific address", addr)
+	}
+
+	l := &raftStreamLayer{
+		listener: listener,
+		token:    token,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	go l.run()
+
+	return l, nil
+}
+
+// run authenticates the connections accepted, each on its own so that a
+// slow peer does not hold the others back.
+func (l *raftStreamLayer) run() {
+
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			select {
+			case <-l.closed:
+				return
+			default:
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			l.Close()
+			return
+		}
+
+		go func() {
+			err := l.challenge(conn)
+			if err != nil {
+				log.Printf("Rejecting raft connection from %s: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+
+			select {
+			case l.conns <- conn:
+			case <-l.closed:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// challenge checks that the peer of conn knows the token.
+func (l *raftStreamLayer) challenge(conn net.Conn) error {
+
+	conn.SetDeadline(time.Now().Add(raftHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	nonce := make([]byte, sha256.Size)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(nonce)
+	if err != nil {
+		return err
+	}
+
+	answer := make([]byte, sha256.Size)
+	_, err = io.ReadFull(conn, answer)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(answer, raftHandshakeMAC(l.token, nonce)) {
+		return fmt.Errorf("invalid token")
+	}
+
+	return nil
+}
+
+func (l *raftStreamLayer) Accept() (net.Conn, error) {
+
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *raftStreamLayer) Close() error {
+
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.listener.Close()
+	})
+
+	return err
+}
+
+func (l *raftStreamLayer) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Dial connects to the Raft transport of another node and answers its
+// challenge.
+func (l *raftStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+
+	conn, err := net.DialTimeout("tcp", string(address), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(raftHandshakeTimeout))
+	nonce := make([]byte, sha256.Size)
+	_, err = io.ReadFull(conn, nonce)
+	if err == nil {
+		_, err = conn.Write(raftHandshakeMAC(l.token, nonce))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+func raftHandshakeMAC(token string, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
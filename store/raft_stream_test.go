@@ -0,0 +1,80 @@
+package store
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestRaftStreamLayer(t *testing.T) {
+
+	server, err := newRaftStreamLayer("127.0.0.1:0", "secret")
+	if err != nil {
+		t.Fatalf("newRaftStreamLayer: %v", err)
+	}
+	defer server.Close()
+
+	accepted := make(chan []byte, 1)
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			data := make([]byte, 4)
+			io.ReadFull(conn, data)
+			conn.Close()
+			accepted <- data
+		}
+	}()
+
+	cases := []struct {
+		name     string
+		token    string
+		accepted bool
+	}{
+		{
+			name:  "wrong token",
+			token: "wrong",
+		},
+		{
+			name:     "token of the cluster",
+			token:    "secret",
+			accepted: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := &raftStreamLayer{token: c.token}
+			conn, err := client.Dial(raft.ServerAddress(server.Addr().String()), time.Second)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+			conn.Write([]byte("ping"))
+
+			// A rejected connection is closed without reaching Raft
+			select {
+			case data := <-accepted:
+				if !c.accepted || string(data) != "ping" {
+					t.Errorf("Expected accepted %v, got %q", c.accepted, data)
+				}
+			case <-time.After(500 * time.Millisecond):
+				if c.accepted {
+					t.Errorf("Expected the connection to be accepted")
+				}
+			}
+		})
+	}
+}
+
+func TestRaftStreamLayerUnspecifiedAddress(t *testing.T) {
+
+	_, err := newRaftStreamLayer("0.0.0.0:0", "secret")
+	if err == nil {
+		t.Errorf("Expected an error")
+	}
+}
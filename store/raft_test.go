@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// startRaftCluster starts n nodes over loopback, the first one bootstrapping
+// the cluster and the others joining it.
+func startRaftCluster(t *testing.T, n int) []*RaftStore {
+
+	var nodes []*RaftStore
+	for i := 0; i < n; i++ {
+		config := RaftConfig{
+			ID:       string(rune('a' + i)),
+			RaftAddr: "127.0.0.1:0",
+			RPCAddr:  "127.0.0.1:0",
+			Token:    "secret",
+		}
+		if i == 0 {
+			config.Bootstrap = true
+		} else {
+			config.Join = nodes[0].RPCAddr()
+		}
+
+		node, err := NewRaftStore(config)
+		if err != nil {
+			t.Fatalf("NewRaftStore: %v", err)
+		}
+		t.Cleanup(func() { node.Close() })
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+// eventually waits for every node to return expected for table and id.
+func eventually(t *testing.T, nodes []*RaftStore, table, id string, expected map[string]string) {
+
+	ctx := context.Background()
+	deadline := time.Now().Add(10 * time.Second)
+	for _, node := range nodes {
+		for {
+			entry, _ := node.Get(ctx, table, id)
+			values := entry.GetAttributes()
+			if reflect.DeepEqual(values, expected) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %v, got %v", expected, values)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+func TestRaftStore(t *testing.T) {
+
+	ctx := context.Background()
+	nodes := startRaftCluster(t, 3)
+	follower := nodes[1]
+	if follower.IsLeader() {
+		follower = nodes[2]
+	}
+
+	// Writes through a follower are forwarded to the leader
+	_, current, err := follower.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}, Owner: "pablo"}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	expected := map[string]string{"Info": "Test", "FencingToken": "1"}
+	if !reflect.DeepEqual(current.Attributes, expected) {
+		t.Errorf("Expected %v, got %v", expected, current.Attributes)
+	}
+	eventually(t, nodes, "terraform-lock-table", "tfstates/network", expected)
+
+	cases := []struct {
+		name        string
+		apply       func(s *RaftStore) error
+		expectedErr error
+	}{
+		{
+			name: "acquire a held lock",
+			apply: func(s *RaftStore) error {
+				_, _, err := s.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test2"}}, NotExists)
+				return err
+			},
+			expectedErr: ErrConditionalCheckFailed,
+		},
+		{
+			name: "release someone else's lock",
+			apply: func(s *RaftStore) error {
+				_, err := s.Delete(ctx, "terraform-lock-table", "tfstates/network", ConditionFunc(func(entry *Entry) bool {
+					return entry != nil && entry.Owner == "someone"
+				}))
+				return err
+			},
+			expectedErr: ErrConditionalCheckFailed,
+		},
+		{
+			name: "release",
+			apply: func(s *RaftStore) error {
+				_, err := s.Delete(ctx, "terraform-lock-table", "tfstates/network", ConditionFunc(func(entry *Entry) bool {
+					return entry != nil && entry.Owner == "pablo"
+				}))
+				return err
+			},
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.apply(nodes[i%len(nodes)]); !errors.Is(err, c.expectedErr) {
+				t.Errorf("Expected error %v, got %v", c.expectedErr, err)
+			}
+		})
+	}
+	eventually(t, nodes, "terraform-lock-table", "tfstates/network", nil)
+}
+
+func TestRaftStoreFailover(t *testing.T) {
+
+	ctx := context.Background()
+	nodes := startRaftCluster(t, 3)
+
+	var leader int
+	for i, node := range nodes {
+		if node.IsLeader() {
+			leader = i
+		}
+	}
+
+	// Remove the leader for good, the two remaining nodes elect a new one
+	err := nodes[leader].Leave(nodes[leader].config.ID)
+	if err != nil {
+		t.Fatalf("Leave: %v", err)
+	}
+	nodes[leader].Close()
+	nodes = append(nodes[:leader:leader], nodes[leader+1:]...)
+
+	_, _, err = nodes[0].Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	eventually(t, nodes, "terraform-lock-table", "tfstates/network", map[string]string{"Info": "Test", "FencingToken": "1"})
+}
+
+func TestRaftStoreRPCAuthentication(t *testing.T) {
+
+	nodes := startRaftCluster(t, 1)
+
+	cases := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+	}{
+		{
+			name:           "no token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong token",
+			authorization:  "Bearer wrong",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "token of the cluster",
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://"+nodes[0].RPCAddr()+"/raft/join", strings.NewReader("{}"))
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			if c.authorization != "" {
+				req.Header.Set("Authorization", c.authorization)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != c.expectedStatus {
+				t.Errorf("Expected %d, got %d", c.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRaftFSMInvalidCommand(t *testing.T) {
+
+	fsm := &raftFSM{state: NewInMemoryStore(), nodes: make(map[string]raftNode)}
+
+	result := fsm.Apply(&raft.Log{Index: 1, Data: []byte("not json")})
+	if _, ok := result.(error); !ok {
+		t.Errorf("Expected an error, got %v", result)
+	}
+}
+
+func TestInMemorySnapshotEmptyTable(t *testing.T) {
+
+	ctx := context.Background()
+	s := NewInMemoryStore()
+	_, _, err := s.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, err = s.Delete(ctx, "terraform-lock-table", "tfstates/network", nil)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	data, err := json.Marshal(s.snapshot())
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var snapshot inMemorySnapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	// The table still exists once restored, only the entry is missing
+	restored := NewInMemoryStore()
+	restored.restore(snapshot)
+	_, err = restored.Get(ctx, "terraform-lock-table", "tfstates/network")
+	if !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("Expected error %v, got %v", ErrEntryNotFound, err)
+	}
+}
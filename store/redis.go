@@ -0,0 +1,441 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisRetries bounds the attempts of a write whose watched keys keep
+// changing.
+const redisRetries = 100
+
+// redisPut replaces an entry, keeping the fencing token and creation time
+// of a lock or incrementing its counter when the lock is created, and returns
+// the token and the creation time. KEYS are the entry, the ids of its table,
+// the fencing counters of its table and the table names. ARGV are the table,
+// the id, whether it is a lock, the owner, the Unix time it expires at or 0,
+// the Unix time in nanoseconds it is written at, then attribute names and
+// values.
+var redisPut = redis.NewScript(`
+local token = redis.call('HGET', KEYS[1], 'token')
+local created = redis.call('HGET', KEYS[1], 'created')
+if not token then
+	token = '0'
+	created = ARGV[6]
+	if ARGV[3] == '1' then
+		token = tostring(redis.call('HINCRBY', KEYS[3], ARGV[2], 1))
+	end
+end
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], 'owner', ARGV[4], 'token', token, 'updated', ARGV[6])
+if created then
+	redis.call('HSET', KEYS[1], 'created', created)
+end
+for i = 7, #ARGV, 2 do
+	redis.call('HSET', KEYS[1], 'attr:' .. ARGV[i], ARGV[i + 1])
+end
+if ARGV[5] ~= '0' then
+	redis.call('EXPIREAT', KEYS[1], ARGV[5])
+end
+redis.call('ZADD', KEYS[2], 0, ARGV[2])
+redis.call('SADD', KEYS[4], ARGV[1])
+return {tonumber(token), created or '0'}
+`)
+
+// redisForget removes the id of an entry that expired from its table.
+var redisForget = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+end
+return 0
+`)
+
+// RedisStore is a Store kept in Redis. An entry is a hash holding its owner,
+// its fencing token, its creation and update times and its attributes, the ids of a table are a sorted set
+// scanned in lexicographic order. Writes watch the entries they check and are
+// retried when one changes before they commit.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+	// ttlAttribute holds the Unix time entries expire at, as the TTL
+	// attribute of DynamoDB, when not empty
+	ttlAttribute string
+}
+
+// NewRedisStore returns a store kept in Redis through client, a single node
+// or Sentinel client, under keys starting with prefix. Redis Cluster is not
+// supported as writes span keys of different hash slots. Entries whose
+// ttlAttribute, when not empty, holds a Unix time expire at that time.
+func NewRedisStore(client redis.UniversalClient, prefix, ttlAttribute string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix, ttlAttribute: ttlAttribute}
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *RedisStore) tablesKey() string {
+	return s.prefix + "tables"
+}
+
+func (s *RedisStore) idsKey(table string) string {
+	return s.prefix + "ids:" + table
+}
+
+func (s *RedisStore) fencingKey(table string) string {
+	return s.prefix + "fencing:" + table
+}
+
+// entryKey length-prefixes table, so that a table and an id containing a
+// colon cannot make the key of another entry.
+func (s *RedisStore) entryKey(table, id string) string {
+	return s.prefix + "entry:" + strconv.Itoa(len(table)) + ":" + table + ":" + id
+}
+
+func (s *RedisStore) Get(ctx context.Context, table, id string) (*Entry, error) {
+
+	exists, err := s.client.SIsMember(ctx, s.tablesKey(), table).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, tableNotFound(table)
+	}
+
+	entry, err := s.get(ctx, s.client, table, id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, entryNotFound(table, id)
+	}
+
+	return entry, nil
+}
+
+func (s *RedisStore) Put(ctx context.Context, table string, item Item, condition Condition) (*Entry, *Entry, error) {
+
+	var old, current *Entry
+	err := s.watch(ctx, []string{s.entryKey(table, item.ID)}, func(tx *redis.Tx) error {
+		var err error
+		old, err = s.get(ctx, tx, table, item.ID)
+		if err != nil {
+			return err
+		}
+		if condition != nil && !condition.Holds(old) {
+			current = old
+			return conditionFailed(table, item.ID)
+		}
+
+		var written *redis.Cmd
+		now := time.Now().UTC()
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			written = s.put(ctx, pipe, table, item.ID, item.Attributes, item.Owner, now)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		token, created := redisWritten(written)
+		current = &Entry{
+			ID:         item.ID,
+			Kind:       Kind(item.ID),
+			Attributes: redisAttributes(item.Attributes, token),
+			Owner:      item.Owner,
+			Created:    created,
+			Updated:    now,
+		}
+		return nil
+	})
+
+	return old, current, err
+}
+
+func (s *RedisStore) Delete(ctx context.Context, table, id string, condition Condition) (*Entry, error) {
+
+	var old *Entry
+	err := s.watch(ctx, []string{s.entryKey(table, id)}, func(tx *redis.Tx) error {
+		entry, err := s.get(ctx, tx, table, id)
+		if err != nil {
+			return err
+		}
+		old = nil
+		if condition != nil && !condition.Holds(entry) {
+			old = entry
+			return conditionFailed(table, id)
+		}
+
+		exists, err := tx.SIsMember(ctx, s.tablesKey(), table).Result()
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return tableNotFound(table)
+		}
+		if entry == nil {
+			return entryNotFound(table, id)
+		}
+		old = entry
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, s.entryKey(table, id))
+			pipe.ZRem(ctx, s.idsKey(table), id)
+			return nil
+		})
+		return err
+	})
+
+	return old, err
+}
+
+func (s *RedisStore) Scan(ctx context.Context, table, exclusiveStartID string, limit int) ([]Entry, error) {
+
+	exists, err := s.client.SIsMember(ctx, s.tablesKey(), table).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, tableNotFound(table)
+	}
+
+	result := []Entry{}
+	min := "-"
+	if exclusiveStartID != "" {
+		min = "(" + exclusiveStartID
+	}
+	for {
+		count := int64(100)
+		if limit > 0 {
+			count = int64(limit - len(result))
+		}
+		ids, err := s.client.ZRangeByLex(ctx, s.idsKey(table), &redis.ZRangeBy{Min: min, Max: "+", Count: count}).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range ids {
+			entry, err := s.get(ctx, s.client, table, id)
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				err = redisForget.Run(ctx, s.client, []string{s.entryKey(table, id), s.idsKey(table)}, id).Err()
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			result = append(result, *entry)
+		}
+
+		if int64(len(ids)) < count || (limit > 0 && len(result) == limit) {
+			return result, nil
+		}
+		min = "(" + ids[len(ids)-1]
+	}
+}
+
+func (s *RedisStore) TransactWrite(ctx context.Context, ops []WriteOp) error {
+
+	var keys []string
+	for _, op := range ops {
+		keys = append(keys, s.entryKey(op.Table, op.ID))
+	}
+
+	now := time.Now().UTC()
+	return s.watch(ctx, keys, func(tx *redis.Tx) error {
+		entries := make([]*Entry, len(ops))
+		reasons := make([]error, len(ops))
+		items := make([]*Entry, len(ops))
+		var canceled bool
+		for i, op := range ops {
+			entry, err := s.get(ctx, tx, op.Table, op.ID)
+			if err != nil {
+				return err
+			}
+			entries[i] = entry
+
+			if op.Condition != nil && !op.Condition.Holds(entry) {
+				reasons[i] = ErrConditionalCheckFailed
+				items[i] = entry
+				canceled = true
+			}
+		}
+
+		if canceled {
+			return &TransactionCanceledError{Reasons: reasons, Items: items}
+		}
+
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, op := range ops {
+				switch op.Kind {
+				case WritePut:
+					s.put(ctx, pipe, op.Table, op.ID, op.Attributes, op.Owner, now)
+				case WriteUpdate:
+					attributes := map[string]string{}
+					owner := op.Owner
+					if entries[i] != nil {
+						attributes = entries[i].Attributes
+						owner = entries[i].Owner
+					}
+					for k, v := range op.Attributes {
+						attributes[k] = v
+					}
+					for _, k := range op.Remove {
+						delete(attributes, k)
+					}
+					s.put(ctx, pipe, op.Table, op.ID, attributes, owner, now)
+				case WriteDelete:
+					pipe.Del(ctx, s.entryKey(op.Table, op.ID))
+					pipe.ZRem(ctx, s.idsKey(op.Table), op.ID)
+				}
+			}
+			return nil
+		})
+		return err
+	})
+}
+
+func (s *RedisStore) TransactGet(ctx context.Context, keys []Key) ([]*Entry, error) {
+
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HGetAll(ctx, s.entryKey(key.Table, key.ID))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Entry, len(keys))
+	for i, key := range keys {
+		result[i] = redisEntry(key.ID, cmds[i].Val())
+	}
+
+	return result, nil
+}
+
+func (s *RedisStore) Tables(ctx context.Context) ([]string, error) {
+
+	tables, err := s.client.SMembers(ctx, s.tablesKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tables)
+
+	return tables, nil
+}
+
+// watch runs f until the keys it watches do not change before it commits.
+func (s *RedisStore) watch(ctx context.Context, keys []string, f func(tx *redis.Tx) error) error {
+
+	for i := 0; i < redisRetries; i++ {
+		err := s.client.Watch(ctx, f, keys...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return fmt.Errorf("too many concurrent writes to %s", strings.Join(keys, ", "))
+}
+
+// get returns an entry, or nil if it does not exist.
+func (s *RedisStore) get(ctx context.Context, c redis.Cmdable, table, id string) (*Entry, error) {
+
+	values, err := c.HGetAll(ctx, s.entryKey(table, id)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return redisEntry(id, values), nil
+}
+
+// put queues the replacement of an entry written at now, the command returns
+// its fencing token and creation time once run.
+func (s *RedisStore) put(ctx context.Context, pipe redis.Pipeliner, table, id string, attributes map[string]string, owner string, now time.Time) *redis.Cmd {
+
+	lock := "0"
+	if Kind(id) == KindLock {
+		lock = "1"
+	}
+
+	var expireAt int64
+	if s.ttlAttribute != "" {
+		expireAt, _ = strconv.ParseInt(attributes[s.ttlAttribute], 10, 64)
+	}
+
+	args := []interface{}{table, id, lock, owner, expireAt, now.UnixNano()}
+	for k, v := range attributes {
+		if k != FencingTokenAttribute {
+			args = append(args, k, v)
+		}
+	}
+
+	keys := []string{s.entryKey(table, id), s.idsKey(table), s.fencingKey(table), s.tablesKey()}
+	return redisPut.Eval(ctx, pipe, keys, args...)
+}
+
+func redisEntry(id string, values map[string]string) *Entry {
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	attributes := map[string]string{}
+	for k, v := range values {
+		if name, ok := strings.CutPrefix(k, "attr:"); ok {
+			attributes[name] = v
+		}
+	}
+	if token := values["token"]; token != "" && token != "0" {
+		attributes[FencingTokenAttribute] = token
+	}
+
+	return &Entry{ID: id, Kind: Kind(id), Attributes: attributes, Owner: values["owner"], Created: redisTime(values["created"]), Updated: redisTime(values["updated"])}
+}
+
+// redisTime parses a Unix time in nanoseconds, the zero time when missing.
+func redisTime(value string) time.Time {
+	ns, _ := strconv.ParseInt(value, 10, 64)
+	return unixNano(ns)
+}
+
+// redisWritten returns the fencing token and creation time returned by
+// redisPut.
+func redisWritten(cmd *redis.Cmd) (int64, time.Time) {
+
+	values, _ := cmd.Slice()
+	if len(values) != 2 {
+		return 0, time.Time{}
+	}
+	token, _ := values[0].(int64)
+	created, _ := values[1].(string)
+
+	return token, redisTime(created)
+}
+
+// redisAttributes returns the attributes of an entry written with the
+// fencing token returned by redisPut.
+func redisAttributes(attributes map[string]string, token int64) map[string]string {
+
+	result := map[string]string{}
+	for k, v := range attributes {
+		if k != FencingTokenAttribute {
+			result[k] = v
+		}
+	}
+	if token != 0 {
+		result[FencingTokenAttribute] = strconv.FormatInt(token, 10)
+	}
+
+	return result
+}
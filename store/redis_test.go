@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisStoreTTL(t *testing.T) {
+
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	now := time.Now()
+	server.SetTime(now)
+	s := NewRedisStore(redis.NewClient(&redis.Options{Addr: server.Addr()}), "locker:", "ExpiresAt")
+	defer s.Close()
+
+	expiresAt := strconv.FormatInt(now.Add(time.Minute).Unix(), 10)
+	_, _, err := s.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test", "ExpiresAt": expiresAt}}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	server.FastForward(2 * time.Minute)
+
+	// The lock expired, it can be acquired again with a new fencing token
+	_, err = s.Get(ctx, "terraform-lock-table", "tfstates/network")
+	if !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("Expected error %v, got %v", ErrEntryNotFound, err)
+	}
+	entries, err := s.Scan(ctx, "terraform-lock-table", "", 0)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected no entries, got %v %v", entries, err)
+	}
+	_, current, err := s.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test2"}}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if current.Attributes[FencingTokenAttribute] != "2" {
+		t.Errorf("Expected %q, got %q", "2", current.Attributes[FencingTokenAttribute])
+	}
+}
+
+func TestRedisStoreKeys(t *testing.T) {
+
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	s := NewRedisStore(redis.NewClient(&redis.Options{Addr: server.Addr()}), "locker:", "")
+	defer s.Close()
+
+	// Both would be stored under entry:a:b:c without the table length
+	_, _, err := s.Put(ctx, "a:b", Item{ID: "c", Attributes: map[string]string{"Info": "Test"}}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, _, err = s.Put(ctx, "a", Item{ID: "b:c", Attributes: map[string]string{"Info": "Test2"}}, NotExists)
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	entry, err := s.Get(ctx, "a:b", "c")
+	if err != nil || entry.Attributes["Info"] != "Test" {
+		t.Errorf("Expected %v, got %v %v", "Test", entry, err)
+	}
+}
@@ -0,0 +1,467 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrReadOnly = fmt.Errorf("store is a read-only replica")
+
+const (
+	// replicationLogSize bounds the records kept for replicas catching up,
+	// a replica further behind starts over from a snapshot.
+	replicationLogSize = 10000
+	// replicationHeartbeat is how often an idle stream tells the replica
+	// where the primary is.
+	replicationHeartbeat = 5 * time.Second
+)
+
+// ReplicationRecord is the state of the entries changed by a write once
+// applied, in the order of the primary.
+type ReplicationRecord struct {
+	Seq     uint64              `json:"Seq"`
+	Time    time.Time           `json:"Time"`
+	Changes []ReplicationChange `json:"Changes"`
+}
+
+// ReplicationChange is the state of an entry, Entry being nil once it is
+// deleted. Fencing is the fencing counter of the entry.
+type ReplicationChange struct {
+	Table   string                 `json:"Table"`
+	ID      string                 `json:"ID"`
+	Entry   *inMemorySnapshotEntry `json:"Entry"`
+	Fencing uint64                 `json:"Fencing"`
+}
+
+// replicationMessage is a line of the replication stream: a snapshot to
+// start from, a record or a heartbeat.
+type replicationMessage struct {
+	Snapshot  *inMemorySnapshot  `json:"Snapshot,omitempty"`
+	Record    *ReplicationRecord `json:"Record,omitempty"`
+	Heartbeat bool               `json:"Heartbeat,omitempty"`
+	Seq       uint64             `json:"Seq"`
+	Time      time.Time          `json:"Time"`
+}
+
+// ReplicatedStore is an InMemoryStore whose writes are shipped to replicas.
+// A primary accepts writes and logs them, a replica applies the log of its
+// primary in order and rejects writes with ErrReadOnly until it is promoted.
+// Replicas log the records they apply under the primary's sequence numbers,
+// so that the other replicas can follow a promoted replica.
+type ReplicatedStore struct {
+	state *InMemoryStore
+
+	// writes serializes writes so that they are logged in order
+	writes sync.Mutex
+
+	mu       sync.Mutex
+	seq      uint64
+	records  []ReplicationRecord
+	appended chan struct{}
+
+	replica    bool
+	cancel     context.CancelFunc
+	done       chan struct{}
+	primarySeq uint64
+	lastSync   time.Time
+}
+
+func NewPrimary() *ReplicatedStore {
+	return &ReplicatedStore{
+		state:    NewInMemoryStore(),
+		appended: make(chan struct{}),
+	}
+}
+
+// NewReplica starts following the replication stream served by the
+// primary at primaryURL, authenticating with token.
+func NewReplica(primaryURL, token string, client *http.Client) *ReplicatedStore {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &ReplicatedStore{
+		state:    NewInMemoryStore(),
+		appended: make(chan struct{}),
+		replica:  true,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	go s.follow(ctx, primaryURL, token, client)
+
+	return s
+}
+
+// IsReplica reports whether the store rejects writes.
+func (s *ReplicatedStore) IsReplica() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.replica
+}
+
+// Promote stops following the primary and starts accepting writes.
+func (s *ReplicatedStore) Promote() error {
+
+	s.mu.Lock()
+	if !s.replica {
+		s.mu.Unlock()
+		return fmt.Errorf("store is already a primary")
+	}
+	s.mu.Unlock()
+
+	s.cancel()
+	<-s.done
+
+	s.mu.Lock()
+	s.replica = false
+	s.mu.Unlock()
+	log.Printf("Promoted to primary at %d", s.LastSeq())
+
+	return nil
+}
+
+// Close stops following the primary.
+func (s *ReplicatedStore) Close() error {
+
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+
+	return nil
+}
+
+// Lag returns how many records a replica is known to be behind its primary,
+// and for how long it has not been in sync with it.
+func (s *ReplicatedStore) Lag() (uint64, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.replica {
+		return 0, 0
+	}
+
+	var records uint64
+	if s.primarySeq > s.seq {
+		records = s.primarySeq - s.seq
+	}
+	if s.lastSync.IsZero() {
+		return records, 0
+	}
+
+	return records, time.Since(s.lastSync)
+}
+
+// LastSeq returns the sequence number of the last record logged.
+func (s *ReplicatedStore) LastSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.seq
+}
+
+func (s *ReplicatedStore) Get(ctx context.Context, table, id string) (*Entry, error) {
+	return s.state.Get(ctx, table, id)
+}
+
+func (s *ReplicatedStore) Scan(ctx context.Context, table, exclusiveStartID string, limit int) ([]Entry, error) {
+	return s.state.Scan(ctx, table, exclusiveStartID, limit)
+}
+
+func (s *ReplicatedStore) TransactGet(ctx context.Context, keys []Key) ([]*Entry, error) {
+	return s.state.TransactGet(ctx, keys)
+}
+
+func (s *ReplicatedStore) Tables(ctx context.Context) ([]string, error) {
+	return s.state.Tables(ctx)
+}
+
+func (s *ReplicatedStore) Put(ctx context.Context, table string, item Item, condition Condition) (*Entry, *Entry, error) {
+
+	if s.IsReplica() {
+		return nil, nil, ErrReadOnly
+	}
+
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	old, current, err := s.state.Put(ctx, table, item, condition)
+	if err == nil {
+		s.log([]Key{{Table: table, ID: item.ID}})
+	}
+
+	return old, current, err
+}
+
+func (s *ReplicatedStore) Delete(ctx context.Context, table, id string, condition Condition) (*Entry, error) {
+
+	if s.IsReplica() {
+		return nil, ErrReadOnly
+	}
+
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	old, err := s.state.Delete(ctx, table, id, condition)
+	if err == nil {
+		s.log([]Key{{Table: table, ID: id}})
+	}
+
+	return old, err
+}
+
+func (s *ReplicatedStore) TransactWrite(ctx context.Context, ops []WriteOp) error {
+
+	if s.IsReplica() {
+		return ErrReadOnly
+	}
+
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	err := s.state.TransactWrite(ctx, ops)
+	if err != nil {
+		return err
+	}
+
+	var keys []Key
+	for _, op := range ops {
+		if op.Kind != WriteConditionCheck {
+			keys = append(keys, Key{Table: op.Table, ID: op.ID})
+		}
+	}
+	s.log(keys)
+
+	return nil
+}
+
+// log records the state of keys after a write. The caller must hold
+// s.writes.
+func (s *ReplicatedStore) log(keys []Key) {
+
+	record := ReplicationRecord{Time: time.Now().UTC()}
+
+	s.state.mu.Lock()
+	for _, key := range keys {
+		change := ReplicationChange{Table: key.Table, ID: key.ID, Fencing: s.state.fencing[key]}
+		if storeEntry, ok := s.state.tables[key.Table].entries[key.ID]; ok {
+			entry := s.state.entry(key.Table, key.ID)
+			change.Entry = &inMemorySnapshotEntry{Attributes: entry.Attributes, Owner: entry.Owner, FencingToken: storeEntry.fencingToken, Created: storeEntry.created, Updated: storeEntry.updated}
+			delete(change.Entry.Attributes, FencingTokenAttribute)
+		}
+		record.Changes = append(record.Changes, change)
+	}
+	s.state.mu.Unlock()
+
+	s.mu.Lock()
+	s.seq++
+	record.Seq = s.seq
+	s.appendLocked(record)
+	s.mu.Unlock()
+}
+
+// appendLocked adds a record to the log and wakes the streams up. The caller
+// must hold s.mu.
+func (s *ReplicatedStore) appendLocked(record ReplicationRecord) {
+
+	s.records = append(s.records, record)
+	if len(s.records) > replicationLogSize {
+		s.records = append(s.records[:0:0], s.records[len(s.records)-replicationLogSize:]...)
+	}
+
+	close(s.appended)
+	s.appended = make(chan struct{})
+}
+
+// apply applies a record of the primary. It holds s.writes like the writes
+// of a primary, so that a replica chained to this one gets snapshots
+// matching their sequence.
+func (s *ReplicatedStore) apply(record ReplicationRecord) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	s.state.mu.Lock()
+	for _, change := range record.Changes {
+		key := Key{Table: change.Table, ID: change.ID}
+		if change.Entry == nil {
+			if storeTable, ok := s.state.tables[change.Table]; ok {
+				delete(storeTable.entries, change.ID)
+			}
+		} else {
+			s.state.put(change.Table, change.ID, change.Entry.Attributes, change.Entry.Owner, change.Entry.Updated)
+			storeEntry := s.state.tables[change.Table].entries[change.ID]
+			storeEntry.fencingToken, storeEntry.created = change.Entry.FencingToken, change.Entry.Created
+			s.state.tables[change.Table].entries[change.ID] = storeEntry
+		}
+		if change.Fencing == 0 {
+			delete(s.state.fencing, key)
+		} else {
+			if s.state.fencing == nil {
+				s.state.fencing = make(map[Key]uint64)
+			}
+			s.state.fencing[key] = change.Fencing
+		}
+	}
+	s.state.mu.Unlock()
+
+	s.mu.Lock()
+	s.seq = record.Seq
+	s.appendLocked(record)
+	s.mu.Unlock()
+}
+
+// ServeReplication streams the log to a replica from the after query
+// parameter, starting with a snapshot when the replica is too far behind.
+// Authentication is left to the caller.
+func (s *ReplicatedStore) ServeReplication(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Streaming is not supported"))
+		return
+	}
+
+	after, _ := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+
+	for {
+		records, appended, resync := s.recordsAfter(after)
+		if resync {
+			// Writes are held so that the snapshot matches its sequence
+			s.writes.Lock()
+			snapshot := s.state.snapshot()
+			seq := s.LastSeq()
+			s.writes.Unlock()
+
+			err := encoder.Encode(replicationMessage{Snapshot: &snapshot, Seq: seq, Time: time.Now().UTC()})
+			if err != nil {
+				return
+			}
+			after = seq
+			flusher.Flush()
+			continue
+		}
+
+		for i := range records {
+			err := encoder.Encode(replicationMessage{Record: &records[i], Seq: records[i].Seq, Time: records[i].Time})
+			if err != nil {
+				return
+			}
+			after = records[i].Seq
+		}
+		if len(records) > 0 {
+			flusher.Flush()
+			continue
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-appended:
+		case <-time.After(replicationHeartbeat):
+			err := encoder.Encode(replicationMessage{Heartbeat: true, Seq: s.LastSeq(), Time: time.Now().UTC()})
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// recordsAfter returns the records logged after seq along with a channel
+// closed on the next one, or reports that they are no longer all logged.
+func (s *ReplicatedStore) recordsAfter(seq uint64) ([]ReplicationRecord, chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seq > s.seq || (seq < s.seq && (len(s.records) == 0 || s.records[0].Seq > seq+1)) {
+		return nil, nil, true
+	}
+
+	var result []ReplicationRecord
+	for _, record := range s.records {
+		if record.Seq > seq {
+			result = append(result, record)
+		}
+	}
+
+	return result, s.appended, false
+}
+
+// follow applies the stream of the primary until ctx is canceled,
+// reconnecting from the last record applied.
+func (s *ReplicatedStore) follow(ctx context.Context, primaryURL, token string, client *http.Client) {
+	defer close(s.done)
+
+	for {
+		err := s.stream(ctx, primaryURL, token, client)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Replicating from %s: %v", primaryURL, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (s *ReplicatedStore) stream(ctx context.Context, primaryURL, token string, client *http.Client) error {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, primaryURL+"?after="+url.QueryEscape(strconv.FormatUint(s.LastSeq(), 10)), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message replicationMessage
+		err = decoder.Decode(&message)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case message.Snapshot != nil:
+			s.writes.Lock()
+			s.state.restore(*message.Snapshot)
+			s.mu.Lock()
+			s.seq, s.records = message.Seq, nil
+			s.mu.Unlock()
+			s.writes.Unlock()
+		case message.Record != nil:
+			s.apply(*message.Record)
+		}
+
+		s.mu.Lock()
+		s.primarySeq = message.Seq
+		if s.seq >= s.primarySeq {
+			s.lastSync = time.Now()
+		}
+		s.mu.Unlock()
+	}
+}
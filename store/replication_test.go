@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// waitReplicated waits for replica to hold the same entries and fencing
+// counters as primary.
+func waitReplicated(t *testing.T, primary, replica *ReplicatedStore) {
+
+	deadline := time.Now().Add(10 * time.Second)
+	for replica.LastSeq() != primary.LastSeq() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected seq %d, got %d", primary.LastSeq(), replica.LastSeq())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expected, got := primary.state.snapshot(), replica.state.snapshot()
+	if !reflect.DeepEqual(expected.Tables, got.Tables) {
+		t.Errorf("Expected %v, got %v", expected.Tables, got.Tables)
+	}
+	if !reflect.DeepEqual(primary.state.fencing, replica.state.fencing) {
+		t.Errorf("Expected %v, got %v", primary.state.fencing, replica.state.fencing)
+	}
+}
+
+func TestReplicatedStore(t *testing.T) {
+
+	ctx := context.Background()
+	primary := NewPrimary()
+	server := httptest.NewServer(http.HandlerFunc(primary.ServeReplication))
+	defer server.Close()
+
+	// Written before the replica starts, it gets them from a snapshot
+	_, _, err := primary.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}, Owner: "pablo"}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, _, err = primary.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network-md5", Attributes: map[string]string{"Digest": "00000000000000000000000000000000"}}, nil)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	replica := NewReplica(server.URL, "", server.Client())
+	defer replica.Close()
+	waitReplicated(t, primary, replica)
+
+	cases := []struct {
+		name  string
+		apply func(s *ReplicatedStore) error
+	}{
+		{
+			name: "release",
+			apply: func(s *ReplicatedStore) error {
+				_, err := s.Delete(ctx, "terraform-lock-table", "tfstates/network", nil)
+				return err
+			},
+		},
+		{
+			name: "acquire again",
+			apply: func(s *ReplicatedStore) error {
+				_, _, err := s.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test2"}, Owner: "alice"}, NotExists)
+				return err
+			},
+		},
+		{
+			name: "transaction",
+			apply: func(s *ReplicatedStore) error {
+				return s.TransactWrite(ctx, []WriteOp{
+					{Kind: WriteUpdate, Table: "terraform-lock-table", ID: "tfstates/network-md5", Attributes: map[string]string{"Digest": "11111111111111111111111111111111"}},
+					{Kind: WritePut, Table: "other-table", ID: "tfstates/dns", Attributes: map[string]string{"Info": "Test3"}},
+				})
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.apply(primary); err != nil {
+				t.Fatalf("Apply on primary: %v", err)
+			}
+			waitReplicated(t, primary, replica)
+
+			if err := c.apply(replica); !errors.Is(err, ErrReadOnly) {
+				t.Errorf("Expected error %v, got %v", ErrReadOnly, err)
+			}
+		})
+	}
+
+	records, _ := replica.Lag()
+	if records != 0 {
+		t.Errorf("Expected %v, got %v", 0, records)
+	}
+
+	// A promoted replica accepts writes and carries on with the fencing
+	// counters of the primary
+	err = replica.Promote()
+	if err != nil {
+		t.Fatalf("Promote: %v", err)
+	}
+	_, err = replica.Delete(ctx, "terraform-lock-table", "tfstates/network", nil)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, current, err := replica.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test4"}}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	expected := map[string]string{"Info": "Test4", "FencingToken": "3"}
+	if !reflect.DeepEqual(current.Attributes, expected) {
+		t.Errorf("Expected %v, got %v", expected, current.Attributes)
+	}
+
+	// Other replicas follow the promoted replica from where they are
+	promoted := httptest.NewServer(http.HandlerFunc(replica.ServeReplication))
+	defer promoted.Close()
+	other := NewReplica(promoted.URL, "", promoted.Client())
+	defer other.Close()
+	waitReplicated(t, replica, other)
+}
+
+func TestReplicatedStoreEmptyTable(t *testing.T) {
+
+	ctx := context.Background()
+	primary := NewPrimary()
+	server := httptest.NewServer(http.HandlerFunc(primary.ServeReplication))
+	defer server.Close()
+
+	_, _, err := primary.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, err = primary.Delete(ctx, "terraform-lock-table", "tfstates/network", nil)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	// With the log trimmed, the emptied table comes in a snapshot
+	primary.mu.Lock()
+	primary.records = nil
+	primary.mu.Unlock()
+
+	replica := NewReplica(server.URL, "", server.Client())
+	defer replica.Close()
+	waitReplicated(t, primary, replica)
+
+	_, err = replica.Get(ctx, "terraform-lock-table", "tfstates/network")
+	if !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("Expected error %v, got %v", ErrEntryNotFound, err)
+	}
+}
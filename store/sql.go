@@ -0,0 +1,562 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlDialect holds what differs between the databases supported.
+type sqlDialect struct {
+	// collate makes ids sort bytewise, like the other stores
+	collate string
+	// forUpdate locks the rows selected until the end of the transaction
+	forUpdate string
+	// snapshot are the options of transactions reading from a snapshot
+	snapshot *sql.TxOptions
+	// numbered placeholders replace ? when set
+	numbered bool
+}
+
+var sqlDialects = map[string]sqlDialect{
+	"postgres": {
+		collate:   `COLLATE "C"`,
+		forUpdate: "FOR UPDATE",
+		snapshot:  &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true},
+		numbered:  true,
+	},
+	// SQLite serializes transactions on a single connection, so that rows do
+	// not need to be locked
+	"sqlite": {},
+}
+
+// sqlMigrations create and update the schema, in order. A migration is
+// never changed once released, a new one is appended instead.
+var sqlMigrations = []func(d sqlDialect) string{
+	func(d sqlDialect) string {
+		return fmt.Sprintf(`
+CREATE TABLE locker_tables (
+	name TEXT PRIMARY KEY
+);
+CREATE TABLE locker_items (
+	table_name TEXT NOT NULL REFERENCES locker_tables (name),
+	id TEXT %s NOT NULL,
+	attributes TEXT NOT NULL,
+	owner TEXT NOT NULL,
+	fencing_token BIGINT NOT NULL,
+	PRIMARY KEY (table_name, id)
+);
+CREATE TABLE locker_fencing (
+	table_name TEXT NOT NULL,
+	id TEXT NOT NULL,
+	token BIGINT NOT NULL,
+	PRIMARY KEY (table_name, id)
+);`, d.collate)
+	},
+	// Unix times in nanoseconds, 0 for the entries written before
+	func(d sqlDialect) string {
+		return `
+ALTER TABLE locker_items ADD COLUMN created BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE locker_items ADD COLUMN updated BIGINT NOT NULL DEFAULT 0;`
+	},
+}
+
+// SQLStore is a Store kept in a PostgreSQL or SQLite database, in tables
+// prefixed with locker_. Writes lock the rows of the tables they write to,
+// which serializes the writes to a table and makes conditions hold until
+// they commit.
+type SQLStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// NewSQLStore connects to the database with driver, postgres or sqlite, whose
+// package must be imported, and migrates its schema.
+func NewSQLStore(driver, dsn string) (*SQLStore, error) {
+
+	dialect, ok := sqlDialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported SQL driver %q", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite" {
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &SQLStore{db: db, dialect: dialect}
+	err = s.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// migrate applies the migrations not applied yet, each in a transaction.
+func (s *SQLStore) migrate() error {
+
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS locker_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM locker_migrations`).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for ; version < len(sqlMigrations); version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		// Drivers do not all run several statements at once
+		for _, statement := range strings.Split(sqlMigrations[version](s.dialect), ";") {
+			if strings.TrimSpace(statement) == "" {
+				continue
+			}
+			if _, err = tx.Exec(statement); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			_, err = tx.Exec(s.rebind(`INSERT INTO locker_migrations (version) VALUES (?)`), version+1)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLStore) Get(ctx context.Context, table, id string) (*Entry, error) {
+
+	var entry *Entry
+	err := s.view(ctx, nil, func(tx *sql.Tx) error {
+		exists, err := s.tableExists(ctx, tx, table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return tableNotFound(table)
+		}
+
+		entry, err = s.get(ctx, tx, table, id)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return entryNotFound(table, id)
+		}
+
+		return nil
+	})
+
+	return entry, err
+}
+
+func (s *SQLStore) Put(ctx context.Context, table string, item Item, condition Condition) (*Entry, *Entry, error) {
+
+	var old, current *Entry
+	err := s.update(ctx, []string{table}, true, func(tx *sql.Tx) error {
+		var err error
+		old, err = s.get(ctx, tx, table, item.ID)
+		if err != nil {
+			return err
+		}
+		if condition != nil && !condition.Holds(old) {
+			current = old
+			return conditionFailed(table, item.ID)
+		}
+
+		err = s.put(ctx, tx, table, item.ID, item.Attributes, item.Owner, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		current, err = s.get(ctx, tx, table, item.ID)
+
+		return err
+	})
+
+	return old, current, err
+}
+
+func (s *SQLStore) Delete(ctx context.Context, table, id string, condition Condition) (*Entry, error) {
+
+	var old *Entry
+	err := s.update(ctx, []string{table}, false, func(tx *sql.Tx) error {
+		entry, err := s.get(ctx, tx, table, id)
+		if err != nil {
+			return err
+		}
+		if condition != nil && !condition.Holds(entry) {
+			old = entry
+			return conditionFailed(table, id)
+		}
+
+		exists, err := s.tableExists(ctx, tx, table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return tableNotFound(table)
+		}
+		if entry == nil {
+			return entryNotFound(table, id)
+		}
+		old = entry
+
+		_, err = tx.ExecContext(ctx, s.rebind(`DELETE FROM locker_items WHERE table_name = ? AND id = ?`), table, id)
+		return err
+	})
+
+	return old, err
+}
+
+func (s *SQLStore) Scan(ctx context.Context, table, exclusiveStartID string, limit int) ([]Entry, error) {
+
+	var result []Entry
+	err := s.view(ctx, nil, func(tx *sql.Tx) error {
+		exists, err := s.tableExists(ctx, tx, table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return tableNotFound(table)
+		}
+
+		query := `SELECT id, attributes, owner, fencing_token, created, updated FROM locker_items WHERE table_name = ? AND id > ? ORDER BY id`
+		args := []interface{}{table, exclusiveStartID}
+		if limit > 0 {
+			query += ` LIMIT ?`
+			args = append(args, limit)
+		}
+
+		rows, err := tx.QueryContext(ctx, s.rebind(query), args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		result = []Entry{}
+		for rows.Next() {
+			var id, attributes, owner string
+			var token uint64
+			var created, updated int64
+			err = rows.Scan(&id, &attributes, &owner, &token, &created, &updated)
+			if err != nil {
+				return err
+			}
+
+			entry, err := sqlEntry(id, attributes, owner, token, created, updated)
+			if err != nil {
+				return err
+			}
+			result = append(result, *entry)
+		}
+
+		return rows.Err()
+	})
+
+	return result, err
+}
+
+func (s *SQLStore) TransactWrite(ctx context.Context, ops []WriteOp) error {
+
+	var tables []string
+	for _, op := range ops {
+		tables = append(tables, op.Table)
+	}
+
+	now := time.Now().UTC()
+	return s.update(ctx, tables, true, func(tx *sql.Tx) error {
+		reasons := make([]error, len(ops))
+		items := make([]*Entry, len(ops))
+		var canceled bool
+		for i, op := range ops {
+			if op.Condition == nil {
+				continue
+			}
+
+			entry, err := s.get(ctx, tx, op.Table, op.ID)
+			if err != nil {
+				return err
+			}
+			if !op.Condition.Holds(entry) {
+				reasons[i] = ErrConditionalCheckFailed
+				items[i] = entry
+				canceled = true
+			}
+		}
+
+		if canceled {
+			return &TransactionCanceledError{Reasons: reasons, Items: items}
+		}
+
+		for _, op := range ops {
+			var err error
+			switch op.Kind {
+			case WritePut:
+				err = s.put(ctx, tx, op.Table, op.ID, op.Attributes, op.Owner, now)
+			case WriteUpdate:
+				var entry *Entry
+				entry, err = s.get(ctx, tx, op.Table, op.ID)
+				if err != nil {
+					return err
+				}
+				attributes := map[string]string{}
+				owner := op.Owner
+				if entry != nil {
+					attributes = entry.Attributes
+					owner = entry.Owner
+				}
+				for k, v := range op.Attributes {
+					attributes[k] = v
+				}
+				for _, k := range op.Remove {
+					delete(attributes, k)
+				}
+				err = s.put(ctx, tx, op.Table, op.ID, attributes, owner, now)
+			case WriteDelete:
+				_, err = tx.ExecContext(ctx, s.rebind(`DELETE FROM locker_items WHERE table_name = ? AND id = ?`), op.Table, op.ID)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *SQLStore) TransactGet(ctx context.Context, keys []Key) ([]*Entry, error) {
+
+	result := make([]*Entry, len(keys))
+	err := s.view(ctx, s.dialect.snapshot, func(tx *sql.Tx) error {
+		for i, key := range keys {
+			entry, err := s.get(ctx, tx, key.Table, key.ID)
+			if err != nil {
+				return err
+			}
+			result[i] = entry
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+func (s *SQLStore) Tables(ctx context.Context) ([]string, error) {
+
+	rows, err := s.db.QueryContext(ctx, `SELECT name FROM locker_tables`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := []string{}
+	for rows.Next() {
+		var table string
+		err = rows.Scan(&table)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	return tables, rows.Err()
+}
+
+// view runs f in a read transaction.
+func (s *SQLStore) view(ctx context.Context, opts *sql.TxOptions, f func(tx *sql.Tx) error) error {
+
+	tx, err := s.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	return f(tx)
+}
+
+// update runs f in a transaction holding the rows of tables, created first
+// when create is set, and commits it unless f fails. Tables are locked in
+// order so that concurrent transactions do not deadlock.
+func (s *SQLStore) update(ctx context.Context, tables []string, create bool, f func(tx *sql.Tx) error) error {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tables = append([]string(nil), tables...)
+	sort.Strings(tables)
+	for i, table := range tables {
+		if i > 0 && table == tables[i-1] {
+			continue
+		}
+
+		if create {
+			_, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO locker_tables (name) VALUES (?) ON CONFLICT (name) DO NOTHING`), table)
+			if err != nil {
+				return err
+			}
+		}
+		if s.dialect.forUpdate != "" {
+			_, err = tx.ExecContext(ctx, s.rebind(`SELECT name FROM locker_tables WHERE name = ? `+s.dialect.forUpdate), table)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = f(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStore) tableExists(ctx context.Context, tx *sql.Tx, table string) (bool, error) {
+
+	var name string
+	err := tx.QueryRowContext(ctx, s.rebind(`SELECT name FROM locker_tables WHERE name = ?`), table).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// get returns an entry, or nil if it does not exist.
+func (s *SQLStore) get(ctx context.Context, tx *sql.Tx, table, id string) (*Entry, error) {
+
+	var attributes, owner string
+	var token uint64
+	var created, updated int64
+	err := tx.QueryRowContext(ctx, s.rebind(`SELECT attributes, owner, fencing_token, created, updated FROM locker_items WHERE table_name = ? AND id = ?`), table, id).Scan(&attributes, &owner, &token, &created, &updated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlEntry(id, attributes, owner, token, created, updated)
+}
+
+// put replaces an entry written at now of a table locked by update. A lock
+// keeps its fencing token and gets a new one when created.
+func (s *SQLStore) put(ctx context.Context, tx *sql.Tx, table, id string, attributes map[string]string, owner string, now time.Time) error {
+
+	stored := map[string]string{}
+	for k, v := range attributes {
+		if k != FencingTokenAttribute {
+			stored[k] = v
+		}
+	}
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, s.rebind(`UPDATE locker_items SET attributes = ?, owner = ?, updated = ? WHERE table_name = ? AND id = ?`), string(value), owner, now.UnixNano(), table, id)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated > 0 {
+		return err
+	}
+
+	var token uint64
+	if Kind(id) == KindLock {
+		err = tx.QueryRowContext(ctx, s.rebind(`SELECT token FROM locker_fencing WHERE table_name = ? AND id = ?`), table, id).Scan(&token)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		token++
+
+		_, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO locker_fencing (table_name, id, token) VALUES (?, ?, ?) ON CONFLICT (table_name, id) DO UPDATE SET token = excluded.token`), table, id, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO locker_items (table_name, id, attributes, owner, fencing_token, created, updated) VALUES (?, ?, ?, ?, ?, ?, ?)`), table, id, string(value), owner, token, now.UnixNano(), now.UnixNano())
+
+	return err
+}
+
+// rebind replaces the ? placeholders of query for the dialect.
+func (s *SQLStore) rebind(query string) string {
+
+	if !s.dialect.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func sqlEntry(id, attributes, owner string, token uint64, created, updated int64) (*Entry, error) {
+
+	var values map[string]string
+	err := json.Unmarshal([]byte(attributes), &values)
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = map[string]string{}
+	}
+	if token != 0 {
+		values[FencingTokenAttribute] = strconv.FormatUint(token, 10)
+	}
+
+	return &Entry{ID: id, Kind: Kind(id), Attributes: values, Owner: owner, Created: unixNano(created), Updated: unixNano(updated)}, nil
+}
+
+// unixNano returns the UTC time of a Unix time in nanoseconds, the zero time
+// for 0.
+func unixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns).UTC()
+}
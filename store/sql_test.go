@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLStoreReopen(t *testing.T) {
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "locker.sqlite")
+	s, err := NewSQLStore("sqlite", path)
+	if err != nil {
+		t.Fatalf("NewSQLStore: %v", err)
+	}
+	_, _, err = s.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test"}, Owner: "pablo"}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, err = s.Delete(ctx, "terraform-lock-table", "tfstates/network", nil)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	s.Close()
+
+	// Migrations are only applied once, and fencing counters outlive the
+	// process
+	s, err = NewSQLStore("sqlite", path)
+	if err != nil {
+		t.Fatalf("NewSQLStore: %v", err)
+	}
+	defer s.Close()
+
+	_, current, err := s.Put(ctx, "terraform-lock-table", Item{ID: "tfstates/network", Attributes: map[string]string{"Info": "Test2"}}, NotExists)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if current.Attributes[FencingTokenAttribute] != "2" {
+		t.Errorf("Expected %q, got %q", "2", current.Attributes[FencingTokenAttribute])
+	}
+}
+
+func TestSQLStoreRebind(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		driver   string
+		query    string
+		expected string
+	}{
+		{
+			name:     "postgres",
+			driver:   "postgres",
+			query:    "SELECT id FROM locker_items WHERE table_name = ? AND id > ?",
+			expected: "SELECT id FROM locker_items WHERE table_name = $1 AND id > $2",
+		},
+		{
+			name:     "sqlite",
+			driver:   "sqlite",
+			query:    "SELECT id FROM locker_items WHERE table_name = ? AND id > ?",
+			expected: "SELECT id FROM locker_items WHERE table_name = ? AND id > ?",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &SQLStore{dialect: sqlDialects[c.driver]}
+			if query := s.rebind(c.query); query != c.expected {
+				t.Errorf("Expected %v, got %v", c.expected, query)
+			}
+		})
+	}
+}
@@ -1,26 +1,193 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
+// Errors returned by stores wrap these, callers tell them apart with
+// errors.Is.
 var (
-	ErrTableNotFound      = fmt.Errorf("table not found")
-	ErrEntryNotFound      = fmt.Errorf("entry not found")
-	ErrEntryAlreadyExists = fmt.Errorf("entry already exists")
-	ErrInvalidPrimaryKey  = fmt.Errorf("invalid primary key")
+	ErrTableNotFound          = errors.New("table not found")
+	ErrEntryNotFound          = errors.New("entry not found")
+	ErrInvalidPrimaryKey      = errors.New("invalid primary key")
+	ErrConditionalCheckFailed = errors.New("conditional check failed")
 )
 
+// Store holds the tables. Every call gives up with the error of ctx once it
+// is done, a write that already reached the store may still be applied.
 type Store interface {
-	Get(table, id string) (map[string]string, error)
-	Put(table, id string, notExists bool, values map[string]string) error
-	Delete(table, id string) error
+	Get(ctx context.Context, table, id string) (*Entry, error)
+	// Put stores item in table and returns the entry it replaced, nil if it
+	// did not exist, along with the entry now stored. When condition is not
+	// nil and does not hold, nothing is stored and the current entry is
+	// returned as both with ErrConditionalCheckFailed.
+	Put(ctx context.Context, table string, item Item, condition Condition) (*Entry, *Entry, error)
+	// Delete removes an entry and returns it. When condition is not nil and
+	// does not hold, the current entry, nil if it does not exist, is
+	// returned with ErrConditionalCheckFailed.
+	Delete(ctx context.Context, table, id string, condition Condition) (*Entry, error)
+	// Scan returns up to limit entries of table whose id sorts strictly after
+	// exclusiveStartID, in ascending id order. A limit of 0 means no limit.
+	Scan(ctx context.Context, table, exclusiveStartID string, limit int) ([]Entry, error)
+	// TransactWrite applies all ops atomically, or none of them when the
+	// condition of any op fails, in which case a *TransactionCanceledError
+	// is returned.
+	TransactWrite(ctx context.Context, ops []WriteOp) error
+	// TransactGet reads all keys from a single snapshot, missing entries are
+	// returned as nil.
+	TransactGet(ctx context.Context, keys []Key) ([]*Entry, error)
+	// Tables returns the names of all tables in ascending order.
+	Tables(ctx context.Context) ([]string, error)
+}
+
+// Item is an entry to write. Owner is the identity of the caller writing it,
+// if known.
+type Item struct {
+	ID         string
+	Attributes map[string]string
+	Owner      string
+}
+
+// Entry is a single item of a table as read from a store. Owner is the
+// identity of the caller that wrote it, if known. Created is when the entry
+// was created, kept when it is replaced, and Updated when it was last
+// written, both zero for entries written before the store recorded them.
+type Entry struct {
+	ID         string
+	Kind       EntryKind
+	Attributes map[string]string
+	Owner      string
+	Created    time.Time
+	Updated    time.Time
+}
+
+// GetAttributes returns the attributes of e, nil if e is nil.
+func (e *Entry) GetAttributes() map[string]string {
+	if e == nil {
+		return nil
+	}
+	return e.Attributes
+}
+
+type EntryKind int
+
+const (
+	// KindLock is a lock held on a state.
+	KindLock EntryKind = iota
+	// KindDigest is the MD5 digest of a state that Terraform's S3 backend
+	// keeps in the lock table, in a Digest attribute.
+	KindDigest
+)
+
+// FencingTokenAttribute holds the fencing token of a lock. The store assigns
+// it when the lock is acquired, that is when its entry is created, from a
+// per-LockID counter that only ever increases, so that a token tells whether
+// its holder still holds the lock. Values written to it are ignored.
+const FencingTokenAttribute = "FencingToken"
+
+// DigestSuffix is appended by Terraform's S3 backend to the path of a state
+// to form the LockID of its digest entry.
+const DigestSuffix = "-md5"
+
+// Kind returns the kind of the entry designated by id.
+func Kind(id string) EntryKind {
+	if strings.HasSuffix(id, DigestSuffix) {
+		return KindDigest
+	}
+	return KindLock
+}
+
+// Key designates an entry of a table.
+type Key struct {
+	Table string
+	ID    string
+}
+
+// Condition tells whether a conditional write may proceed.
+type Condition interface {
+	// Holds reports whether the write may proceed given the current entry,
+	// which is nil when it does not exist.
+	Holds(entry *Entry) bool
+}
+
+// ConditionFunc is a Condition checked by a function.
+type ConditionFunc func(entry *Entry) bool
+
+func (f ConditionFunc) Holds(entry *Entry) bool {
+	return f(entry)
+}
+
+// NotExists holds when the entry does not exist, to create an entry without
+// replacing it.
+var NotExists Condition = ConditionFunc(func(entry *Entry) bool {
+	return entry == nil
+})
+
+type WriteOpKind int
+
+const (
+	// WritePut replaces the entry with Attributes.
+	WritePut WriteOpKind = iota
+	// WriteUpdate sets Attributes and removes Remove on the entry, creating
+	// it if needed. The owner of an existing entry is kept.
+	WriteUpdate
+	// WriteDelete deletes the entry if it exists.
+	WriteDelete
+	// WriteConditionCheck only checks Condition.
+	WriteConditionCheck
+)
+
+// WriteOp is a single operation of a TransactWrite.
+type WriteOp struct {
+	Kind       WriteOpKind
+	Table      string
+	ID         string
+	Condition  Condition
+	Attributes map[string]string
+	Remove     []string
+	Owner      string
+}
+
+// TransactionCanceledError is returned when a transaction is canceled, with
+// one reason per op: ErrConditionalCheckFailed for the ops whose condition
+// failed and nil for the others. Items holds the current entries of the ops
+// whose condition failed.
+type TransactionCanceledError struct {
+	Reasons []error
+	Items   []*Entry
+}
+
+func (e *TransactionCanceledError) Error() string {
+	return "transaction canceled"
+}
+
+// tableNotFound returns an error wrapping ErrTableNotFound.
+func tableNotFound(table string) error {
+	return fmt.Errorf("%w: %s", ErrTableNotFound, table)
+}
+
+// entryNotFound returns an error wrapping ErrEntryNotFound.
+func entryNotFound(table, id string) error {
+	return fmt.Errorf("%w: %s in %s", ErrEntryNotFound, id, table)
+}
+
+// conditionFailed returns an error wrapping ErrConditionalCheckFailed.
+func conditionFailed(table, id string) error {
+	return fmt.Errorf("%w: %s in %s", ErrConditionalCheckFailed, id, table)
 }
 
 type InMemoryStore struct {
 	mu     sync.Mutex
 	tables map[string]InMemoryStoreTable
+	// fencing holds the last fencing token of each lock, deleted or not
+	fencing map[Key]uint64
 }
 
 type InMemoryStoreTable struct {
@@ -32,6 +199,10 @@ type InMemoryStoreEntry struct {
 		key   string
 		value string
 	}
+	owner        string
+	fencingToken uint64
+	created      time.Time
+	updated      time.Time
 }
 
 func NewInMemoryStore() *InMemoryStore {
@@ -40,77 +211,251 @@ func NewInMemoryStore() *InMemoryStore {
 	}
 }
 
-func (s *InMemoryStore) Get(table, id string) (map[string]string, error) {
+func (s *InMemoryStore) Get(ctx context.Context, table, id string) (*Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	storeTable, ok := s.tables[table]
 	if !ok {
-		return nil, ErrTableNotFound
+		return nil, tableNotFound(table)
 	}
 
-	storeEntry, ok := storeTable.entries[id]
+	_, ok = storeTable.entries[id]
 	if !ok {
-		return nil, ErrEntryNotFound
+		return nil, entryNotFound(table, id)
 	}
 
-	result := make(map[string]string)
-	for _, entry := range storeEntry.attributes {
-		result[entry.key] = entry.value
+	return s.entry(table, id), nil
+}
+
+func (s *InMemoryStore) Put(ctx context.Context, table string, item Item, condition Condition) (*Entry, *Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.entry(table, item.ID)
+	if condition != nil && !condition.Holds(old) {
+		return old, old, conditionFailed(table, item.ID)
 	}
 
-	return result, nil
+	s.put(table, item.ID, item.Attributes, item.Owner, time.Now().UTC())
+
+	return old, s.entry(table, item.ID), nil
 }
 
-func (s *InMemoryStore) Put(table, id string, notExists bool, attributes map[string]string) error {
+func (s *InMemoryStore) Delete(ctx context.Context, table, id string, condition Condition) (*Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	entry := s.entry(table, id)
+	if condition != nil && !condition.Holds(entry) {
+		return entry, conditionFailed(table, id)
+	}
+
 	storeTable, ok := s.tables[table]
 	if !ok {
-		storeTable = InMemoryStoreTable{
-			entries: make(map[string]InMemoryStoreEntry),
+		return nil, tableNotFound(table)
+	}
+
+	if entry != nil {
+		delete(storeTable.entries, id)
+		return entry, nil
+	}
+
+	return nil, entryNotFound(table, id)
+}
+
+func (s *InMemoryStore) Scan(ctx context.Context, table, exclusiveStartID string, limit int) ([]Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	storeTable, ok := s.tables[table]
+	if !ok {
+		return nil, tableNotFound(table)
+	}
+
+	ids := make([]string, 0, len(storeTable.entries))
+	for id := range storeTable.entries {
+		if id > exclusiveStartID {
+			ids = append(ids, id)
 		}
 	}
+	sort.Strings(ids)
+
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
 
-	if notExists {
-		_, ok := storeTable.entries[id]
-		if ok {
-			return ErrEntryAlreadyExists
+	result := make([]Entry, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, *s.entry(table, id))
+	}
+
+	return result, nil
+}
+
+func (s *InMemoryStore) TransactWrite(ctx context.Context, ops []WriteOp) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.transactWrite(ops, time.Now().UTC())
+}
+
+// transactWrite applies ops as written at now.
+func (s *InMemoryStore) transactWrite(ops []WriteOp, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reasons := make([]error, len(ops))
+	items := make([]*Entry, len(ops))
+	var canceled bool
+	for i, op := range ops {
+		if op.Condition == nil {
+			continue
+		}
+
+		entry := s.entry(op.Table, op.ID)
+		if !op.Condition.Holds(entry) {
+			reasons[i] = ErrConditionalCheckFailed
+			items[i] = entry
+			canceled = true
 		}
 	}
 
-	storeEntry := InMemoryStoreEntry{}
-	for key, value := range attributes {
-		storeEntry.attributes = append(storeEntry.attributes, struct {
-			key   string
-			value string
-		}{
-			key:   key,
-			value: value,
-		})
+	if canceled {
+		return &TransactionCanceledError{Reasons: reasons, Items: items}
 	}
 
-	storeTable.entries[id] = storeEntry
-	s.tables[table] = storeTable
+	for _, op := range ops {
+		switch op.Kind {
+		case WritePut:
+			s.put(op.Table, op.ID, op.Attributes, op.Owner, now)
+		case WriteUpdate:
+			attributes := map[string]string{}
+			owner := op.Owner
+			if entry := s.entry(op.Table, op.ID); entry != nil {
+				attributes = entry.Attributes
+				owner = entry.Owner
+			}
+			for k, v := range op.Attributes {
+				attributes[k] = v
+			}
+			for _, k := range op.Remove {
+				delete(attributes, k)
+			}
+			s.put(op.Table, op.ID, attributes, owner, now)
+		case WriteDelete:
+			if storeTable, ok := s.tables[op.Table]; ok {
+				delete(storeTable.entries, op.ID)
+			}
+		}
+	}
 
 	return nil
 }
 
-func (s *InMemoryStore) Delete(table, id string) error {
+func (s *InMemoryStore) TransactGet(ctx context.Context, keys []Key) ([]*Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]*Entry, len(keys))
+	for i, key := range keys {
+		result[i] = s.entry(key.Table, key.ID)
+	}
+
+	return result, nil
+}
+
+func (s *InMemoryStore) Tables(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	tables := make([]string, 0, len(s.tables))
+	for table := range s.tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	return tables, nil
+}
+
+// entry returns a copy of an entry, or nil if it does not exist. The caller
+// must hold s.mu.
+func (s *InMemoryStore) entry(table, id string) *Entry {
+
+	storeEntry, ok := s.tables[table].entries[id]
+	if !ok {
+		return nil
+	}
+
+	attributes := make(map[string]string)
+	for _, entry := range storeEntry.attributes {
+		attributes[entry.key] = entry.value
+	}
+	if storeEntry.fencingToken != 0 {
+		attributes[FencingTokenAttribute] = strconv.FormatUint(storeEntry.fencingToken, 10)
+	}
+
+	return &Entry{ID: id, Kind: Kind(id), Attributes: attributes, Owner: storeEntry.owner, Created: storeEntry.created, Updated: storeEntry.updated}
+}
+
+// put replaces an entry written at now, creating its table if needed. A lock
+// keeps its fencing token and gets a new one when created. The caller must
+// hold s.mu.
+func (s *InMemoryStore) put(table, id string, attributes map[string]string, owner string, now time.Time) {
+
 	storeTable, ok := s.tables[table]
 	if !ok {
-		return ErrTableNotFound
+		storeTable = InMemoryStoreTable{
+			entries: make(map[string]InMemoryStoreEntry),
+		}
 	}
 
-	_, ok = storeTable.entries[id]
-	if ok {
-		delete(storeTable.entries, id)
-		return nil
+	storeEntry := InMemoryStoreEntry{owner: owner, created: now, updated: now}
+	if existing, ok := storeTable.entries[id]; ok {
+		storeEntry.fencingToken = existing.fencingToken
+		storeEntry.created = existing.created
+	} else if Kind(id) == KindLock {
+		if s.fencing == nil {
+			s.fencing = make(map[Key]uint64)
+		}
+		key := Key{Table: table, ID: id}
+		s.fencing[key]++
+		storeEntry.fencingToken = s.fencing[key]
+	}
+
+	for key, value := range attributes {
+		if key == FencingTokenAttribute {
+			continue
+		}
+
+		storeEntry.attributes = append(storeEntry.attributes, struct {
+			key   string
+			value string
+		}{
+			key:   key,
+			value: value,
+		})
 	}
 
-	return ErrEntryNotFound
+	storeTable.entries[id] = storeEntry
+	s.tables[table] = storeTable
 }
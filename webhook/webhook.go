@@ -0,0 +1,514 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	Acquire     EventType = "acquire"
+	Release     EventType = "release"
+	Conflict    EventType = "conflict"
+	ForceUnlock EventType = "force-unlock"
+	// Expiry is sent once when a lock has been held for longer than
+	// Config.HeldAfter.
+	Expiry EventType = "expiry"
+)
+
+// Event is the JSON payload sent to endpoints. Info is the decoded lock info
+// of the lock, that of the current holder on a conflict, and Identity the
+// caller that triggered the event, if known.
+type Event struct {
+	Type     EventType       `json:"Event"`
+	Table    string          `json:"Table"`
+	LockID   string          `json:"LockID"`
+	Identity string          `json:"Identity,omitempty"`
+	Info     json.RawMessage `json:"Info,omitempty"`
+	Time     time.Time       `json:"Time"`
+}
+
+// Endpoint receives the events it subscribed to. Empty Events or LockIDs
+// match everything, LockIDs are globs as understood by path.Match. When
+// Secret is set, payloads are signed with HMAC-SHA256 in the
+// X-Locker-Signature header as sha256=<hex>.
+type Endpoint struct {
+	URL     string      `json:"URL"`
+	Secret  string      `json:"Secret,omitempty"`
+	Events  []EventType `json:"Events,omitempty"`
+	LockIDs []string    `json:"LockIDs,omitempty"`
+}
+
+func (e Endpoint) wants(event Event) bool {
+
+	if len(e.Events) > 0 {
+		var ok bool
+		for _, t := range e.Events {
+			ok = ok || t == event.Type
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if len(e.LockIDs) > 0 {
+		var ok bool
+		for _, pattern := range e.LockIDs {
+			matched, _ := path.Match(pattern, event.LockID)
+			ok = ok || matched
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Duration is a time.Duration written as a string such as "30m" in JSON.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration, err = time.ParseDuration(s)
+
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Config configures a Dispatcher. Deliveries are kept in QueueDir when set so
+// that they survive restarts, and in memory otherwise.
+type Config struct {
+	Endpoints []Endpoint `json:"Endpoints"`
+	QueueDir  string     `json:"QueueDir,omitempty"`
+	// MaxQueued bounds the pending deliveries, new ones are dropped when
+	// it is reached. Defaults to 1000.
+	MaxQueued int `json:"MaxQueued,omitempty"`
+	// MaxAttempts bounds the attempts of a delivery. Defaults to 5.
+	MaxAttempts int `json:"MaxAttempts,omitempty"`
+	// Backoff is the delay before the first retry, doubled on each of the
+	// next ones up to a minute. Defaults to 1s.
+	Backoff Duration `json:"Backoff,omitempty"`
+	// HeldAfter enables Expiry events when not zero.
+	HeldAfter Duration `json:"HeldAfter,omitempty"`
+}
+
+// ParseConfig parses a JSON webhook configuration.
+func ParseConfig(body io.Reader) (Config, error) {
+
+	var config Config
+	err := json.NewDecoder(body).Decode(&config)
+	if err != nil {
+		return Config{}, err
+	}
+
+	for _, endpoint := range config.Endpoints {
+		if endpoint.URL == "" {
+			return Config{}, fmt.Errorf("webhook endpoint URL is missing")
+		}
+	}
+
+	return config, nil
+}
+
+// delivery is the delivery of an event to an endpoint, as stored in the
+// queue.
+type delivery struct {
+	ID          string          `json:"ID"`
+	URL         string          `json:"URL"`
+	Event       EventType       `json:"Event"`
+	Payload     json.RawMessage `json:"Payload"`
+	Attempts    int             `json:"Attempts"`
+	NextAttempt time.Time       `json:"NextAttempt"`
+}
+
+// Dispatcher delivers events to endpoints in the background, with a worker
+// per endpoint so that one failing endpoint does not delay the others.
+type Dispatcher struct {
+	config Config
+	client *http.Client
+
+	mu       sync.Mutex
+	sequence int
+	pending  []*delivery
+	// conflicts holds the lock info of the holder last notified in a
+	// Conflict event, by table and LockID.
+	conflicts map[string]string
+
+	wake    map[string]chan struct{}
+	stop    chan struct{}
+	workers sync.WaitGroup
+}
+
+// New starts a Dispatcher, resuming the deliveries left in QueueDir.
+func New(config Config) (*Dispatcher, error) {
+
+	if config.MaxQueued == 0 {
+		config.MaxQueued = 1000
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 5
+	}
+	if config.Backoff.Duration == 0 {
+		config.Backoff.Duration = time.Second
+	}
+
+	d := &Dispatcher{
+		config:    config,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		conflicts: map[string]string{},
+		wake:      map[string]chan struct{}{},
+		stop:      make(chan struct{}),
+	}
+
+	if config.QueueDir != "" {
+		err := os.MkdirAll(config.QueueDir, 0700)
+		if err != nil {
+			return nil, err
+		}
+
+		d.pending, err = loadQueue(config.QueueDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Deliveries left for endpoints no longer configured are still
+	// attempted
+	for _, endpoint := range config.Endpoints {
+		d.start(endpoint.URL)
+	}
+	for _, delivery := range d.pending {
+		d.start(delivery.URL)
+	}
+
+	return d, nil
+}
+
+// HeldAfter returns how long a lock must be held for an Expiry event, zero
+// when they are disabled.
+func (d *Dispatcher) HeldAfter() time.Duration {
+	return d.config.HeldAfter.Duration
+}
+
+// start runs the worker of url unless it is already running.
+func (d *Dispatcher) start(url string) {
+
+	if _, ok := d.wake[url]; ok {
+		return
+	}
+
+	wake := make(chan struct{}, 1)
+	d.wake[url] = wake
+	d.workers.Add(1)
+	go d.run(url, wake)
+}
+
+// Notify queues event for the endpoints that subscribed to it. It never
+// blocks on delivery. A Conflict is only sent once per holder of the lock,
+// as Terraform retries the acquisition until it gets the lock.
+func (d *Dispatcher) Notify(event Event) {
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Encoding webhook event: %v", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.changed(event) {
+		return
+	}
+
+	for _, endpoint := range d.config.Endpoints {
+		if !endpoint.wants(event) {
+			continue
+		}
+
+		if len(d.pending) >= d.config.MaxQueued {
+			log.Printf("Dropping %s webhook to %s: queue is full", event.Type, endpoint.URL)
+			continue
+		}
+
+		d.sequence++
+		delivery := &delivery{
+			ID:          fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), d.sequence),
+			URL:         endpoint.URL,
+			Event:       event.Type,
+			Payload:     payload,
+			NextAttempt: time.Now(),
+		}
+		if err := d.save(delivery); err != nil {
+			log.Printf("Queueing webhook: %v", err)
+		}
+		d.pending = append(d.pending, delivery)
+
+		select {
+		case d.wake[endpoint.URL] <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// changed reports whether event is not a Conflict already notified for the
+// same holder. Any other event on the lock means it changed hands. The caller
+// must hold d.mu.
+func (d *Dispatcher) changed(event Event) bool {
+
+	key := event.Table + "\x00" + event.LockID
+	if event.Type != Conflict {
+		if event.Type != Expiry {
+			delete(d.conflicts, key)
+		}
+		return true
+	}
+
+	holder, ok := d.conflicts[key]
+	if ok && holder == string(event.Info) {
+		return false
+	}
+	d.conflicts[key] = string(event.Info)
+
+	return true
+}
+
+// Close stops delivering, the deliveries left are resumed by the next
+// Dispatcher using the same QueueDir.
+func (d *Dispatcher) Close() {
+	close(d.stop)
+	d.workers.Wait()
+}
+
+// run attempts the deliveries to url, one at a time.
+func (d *Dispatcher) run(url string, wake chan struct{}) {
+	defer d.workers.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-wake:
+		case <-timer.C:
+		}
+
+		for _, delivery := range d.due(url, time.Now()) {
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+			d.attempt(delivery)
+		}
+
+		timer.Stop()
+		timer = time.NewTimer(d.untilNext(url))
+	}
+}
+
+// due returns the deliveries to url whose next attempt is due, oldest first.
+func (d *Dispatcher) due(url string, now time.Time) []*delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var result []*delivery
+	for _, delivery := range d.pending {
+		if delivery.URL == url && !delivery.NextAttempt.After(now) {
+			result = append(result, delivery)
+		}
+	}
+
+	return result
+}
+
+// untilNext returns the delay until the next attempt to url is due.
+func (d *Dispatcher) untilNext(url string) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	next := time.Minute
+	for _, delivery := range d.pending {
+		if delivery.URL != url {
+			continue
+		}
+		if until := time.Until(delivery.NextAttempt); until < next {
+			next = until
+		}
+	}
+	if next < 0 {
+		next = 0
+	}
+
+	return next
+}
+
+func (d *Dispatcher) attempt(delivery *delivery) {
+
+	err := d.send(delivery)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delivery.Attempts++
+	if err == nil || delivery.Attempts >= d.config.MaxAttempts {
+		if err != nil {
+			log.Printf("Giving up %s webhook to %s after %d attempts: %v", delivery.Event, delivery.URL, delivery.Attempts, err)
+		}
+		d.remove(delivery)
+		return
+	}
+
+	backoff := d.config.Backoff.Duration << (delivery.Attempts - 1)
+	if backoff > time.Minute || backoff <= 0 {
+		backoff = time.Minute
+	}
+	delivery.NextAttempt = time.Now().Add(backoff)
+	log.Printf("Retrying %s webhook to %s in %s: %v", delivery.Event, delivery.URL, backoff, err)
+
+	if err := d.save(delivery); err != nil {
+		log.Printf("Queueing webhook: %v", err)
+	}
+}
+
+func (d *Dispatcher) send(delivery *delivery) error {
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Locker-Event", string(delivery.Event))
+	req.Header.Set("X-Locker-Delivery", delivery.ID)
+
+	// Endpoints are looked up again so that secrets are never queued
+	for _, endpoint := range d.config.Endpoints {
+		if endpoint.URL == delivery.URL && endpoint.Secret != "" {
+			req.Header.Set("X-Locker-Signature", "sha256="+Sign(endpoint.Secret, delivery.Payload))
+			break
+		}
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of payload, for receivers to
+// check the X-Locker-Signature header.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// remove drops a delivery from the queue. The caller must hold d.mu.
+func (d *Dispatcher) remove(delivery *delivery) {
+
+	for i, pending := range d.pending {
+		if pending == delivery {
+			d.pending = append(d.pending[:i:i], d.pending[i+1:]...)
+			break
+		}
+	}
+
+	if d.config.QueueDir != "" {
+		err := os.Remove(filepath.Join(d.config.QueueDir, delivery.ID+".json"))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Removing webhook: %v", err)
+		}
+	}
+}
+
+// save writes a delivery to QueueDir, if any. The caller must hold d.mu.
+func (d *Dispatcher) save(delivery *delivery) error {
+
+	if d.config.QueueDir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(d.config.QueueDir, delivery.ID+".tmp")
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(d.config.QueueDir, delivery.ID+".json"))
+}
+
+// loadQueue reads the deliveries left in dir, oldest first.
+func loadQueue(dir string) ([]*delivery, error) {
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*delivery
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var delivery delivery
+		err = json.Unmarshal(data, &delivery)
+		if err != nil {
+			log.Printf("Skipping webhook %s: %v", file.Name(), err)
+			continue
+		}
+		result = append(result, &delivery)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result, nil
+}
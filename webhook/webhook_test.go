@@ -0,0 +1,221 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver records the events it receives after failing the first failures
+// requests.
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	failures int
+	events   []EventType
+	received chan struct{}
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payload, _ := io.ReadAll(req.Body)
+	if req.Header.Get("X-Locker-Signature") != "sha256="+Sign(r.secret, payload) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var event Event
+	json.Unmarshal(payload, &event)
+	r.events = append(r.events, event.Type)
+	r.received <- struct{}{}
+}
+
+func TestDispatcher(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		failures int
+		endpoint Endpoint
+		events   []Event
+		expected []EventType
+	}{
+		{
+			name:     "deliver subscribed events",
+			endpoint: Endpoint{Events: []EventType{Acquire, ForceUnlock}},
+			events: []Event{
+				{Type: Acquire, LockID: "tfstates/prod/network"},
+				{Type: Release, LockID: "tfstates/prod/network"},
+				{Type: ForceUnlock, LockID: "tfstates/prod/network"},
+			},
+			expected: []EventType{Acquire, ForceUnlock},
+		},
+		{
+			name:     "filter on LockID",
+			endpoint: Endpoint{LockIDs: []string{"tfstates/prod/*"}},
+			events: []Event{
+				{Type: Acquire, LockID: "tfstates/staging/network"},
+				{Type: Conflict, LockID: "tfstates/prod/network"},
+			},
+			expected: []EventType{Conflict},
+		},
+		{
+			name:     "retry failed deliveries",
+			failures: 2,
+			endpoint: Endpoint{},
+			events: []Event{
+				{Type: Acquire, LockID: "tfstates/prod/network"},
+			},
+			expected: []EventType{Acquire},
+		},
+		{
+			name:     "deduplicate conflicts",
+			endpoint: Endpoint{},
+			events: []Event{
+				{Type: Conflict, LockID: "tfstates/prod/network", Info: json.RawMessage(`{"ID":"1"}`)},
+				{Type: Conflict, LockID: "tfstates/prod/network", Info: json.RawMessage(`{"ID":"1"}`)},
+				{Type: Conflict, LockID: "tfstates/prod/dns", Info: json.RawMessage(`{"ID":"1"}`)},
+				{Type: Conflict, LockID: "tfstates/prod/network", Info: json.RawMessage(`{"ID":"2"}`)},
+				{Type: Release, LockID: "tfstates/prod/network", Info: json.RawMessage(`{"ID":"2"}`)},
+				{Type: Conflict, LockID: "tfstates/prod/network", Info: json.RawMessage(`{"ID":"2"}`)},
+			},
+			expected: []EventType{Conflict, Conflict, Conflict, Release, Conflict},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &receiver{secret: "secret", failures: c.failures, received: make(chan struct{}, 10)}
+			server := httptest.NewServer(r)
+			defer server.Close()
+
+			c.endpoint.URL, c.endpoint.Secret = server.URL, "secret"
+			d, err := New(Config{
+				Endpoints: []Endpoint{c.endpoint},
+				QueueDir:  t.TempDir(),
+				Backoff:   Duration{10 * time.Millisecond},
+			})
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			defer d.Close()
+
+			for _, event := range c.events {
+				d.Notify(event)
+			}
+
+			for range c.expected {
+				select {
+				case <-r.received:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Timed out waiting for events")
+				}
+			}
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if !reflect.DeepEqual(r.events, c.expected) {
+				t.Errorf("Expected %v, got %v", c.expected, r.events)
+			}
+		})
+	}
+}
+
+func TestDispatcherResumesQueue(t *testing.T) {
+
+	r := &receiver{secret: "secret", received: make(chan struct{}, 10)}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	config := Config{
+		Endpoints: []Endpoint{{URL: server.URL, Secret: "secret"}},
+		QueueDir:  t.TempDir(),
+		Backoff:   Duration{time.Hour},
+	}
+
+	// Deliveries are queued on disk while the receiver is down
+	r.failures = 1
+	d, err := New(config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	d.Notify(Event{Type: Expiry, LockID: "tfstates/prod/network"})
+	time.Sleep(100 * time.Millisecond)
+	d.Close()
+
+	d, err = New(config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+
+	// The first retry is an hour away, wake the dispatcher up
+	d.mu.Lock()
+	for _, delivery := range d.pending {
+		delivery.NextAttempt = time.Now()
+	}
+	d.mu.Unlock()
+	d.Notify(Event{Type: Release, LockID: "tfstates/prod/network"})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for events")
+		}
+	}
+
+	expected := []EventType{Expiry, Release}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !reflect.DeepEqual(r.events, expected) {
+		t.Errorf("Expected %v, got %v", expected, r.events)
+	}
+}
+
+func TestDispatcherEndpointsIndependent(t *testing.T) {
+
+	// The first endpoint does not answer until the end of the test
+	hung := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-hung
+	}))
+	defer slow.Close()
+
+	r := &receiver{secret: "secret", received: make(chan struct{}, 10)}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	d, err := New(Config{
+		Endpoints: []Endpoint{{URL: slow.URL}, {URL: server.URL, Secret: "secret"}},
+		Backoff:   Duration{10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	defer close(hung)
+
+	d.Notify(Event{Type: Acquire, LockID: "tfstates/prod/network"})
+	d.Notify(Event{Type: Release, LockID: "tfstates/prod/network"})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for events")
+		}
+	}
+}